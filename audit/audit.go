@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of records after which a signature record is added to the log
+const SignEvery = 10
+
+// Event name of the records carrying a signature
+const signatureEvent = "signature"
+
+// Record is a single entry of the audit log. Every record includes the hash of the
+// record before it, so editing, reordering or removing a record breaks the chain.
+type Record struct {
+	Sequence  uint64            `json:"Sequence"`
+	Time      string            `json:"Time"`
+	Event     string            `json:"Event"`
+	Fields    map[string]string `json:"Fields,omitempty"`
+	PrevHash  string            `json:"PrevHash"`
+	Hash      string            `json:"Hash"`
+	Signature string            `json:"Signature,omitempty"`
+}
+
+// Log appends hash-chained records to an audit log file
+type Log struct {
+	mut         sync.Mutex
+	file        *os.File
+	key         ed25519.PrivateKey
+	sequence    uint64
+	lastHash    string
+	sinceSigned int
+}
+
+// computeHash hashes every part of the record except the hash and signature themselves
+func (r Record) computeHash() string {
+	r.Hash = ""
+	r.Signature = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Open opens the audit log for appending, continuing the chain of any existing records.
+// The existing records are verified first, so a log that has been tampered with is not extended.
+func Open(path string, key ed25519.PrivateKey) (*Log, error) {
+	report, err := Verify(path, key.Public().(ed25519.PublicKey), 0)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{
+		file:        file,
+		key:         key,
+		sequence:    report.Records,
+		lastHash:    report.LastHash,
+		sinceSigned: report.Unsigned,
+	}, nil
+}
+
+// Append adds an event to the log, signing the chain if enough records have been added since the last signature
+func (l *Log) Append(event string, fields map[string]string) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if err := l.write(Record{Event: event, Fields: fields}); err != nil {
+		return err
+	}
+	l.sinceSigned++
+
+	if l.sinceSigned >= SignEvery {
+		return l.sign()
+	}
+	return nil
+}
+
+// Sign adds a signature record if any records have been added since the last one
+func (l *Log) Sign() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.sinceSigned == 0 {
+		return nil
+	}
+	return l.sign()
+}
+
+// sign adds a record whose signature covers its own hash, and thereby every record before it
+func (l *Log) sign() error {
+	record := Record{Event: signatureEvent}
+	if err := l.write(record); err != nil {
+		return err
+	}
+	l.sinceSigned = 0
+	return nil
+}
+
+// write chains the record to the previous one and appends it to the file
+func (l *Log) write(record Record) error {
+	record.Sequence = l.sequence + 1
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	record.PrevHash = l.lastHash
+	record.Hash = record.computeHash()
+	if record.Event == signatureEvent {
+		record.Signature = hex.EncodeToString(ed25519.Sign(l.key, []byte(record.Hash)))
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	l.sequence = record.Sequence
+	l.lastHash = record.Hash
+	return nil
+}
+
+// Head returns the sequence number and hash of the last record in the log
+func (l *Log) Head() (uint64, string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.sequence, l.lastHash
+}
+
+// Close signs any unsigned records and closes the file
+func (l *Log) Close() error {
+	err := l.Sign()
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Report summarizes a verified audit log
+type Report struct {
+	Records    uint64
+	Signatures int
+	// Records after the last signature, which could be removed without detection
+	Unsigned int
+	LastHash string
+	// Hash of the record Verify was asked about, empty if the log ends before it
+	HashAt string
+}
+
+// Verify checks that every record in the log is intact, in order and chained to the one
+// before it, and that every signature is valid. The first problem found is returned as an error.
+// The hash of the record with sequence number at is reported, so it can be compared to one recorded elsewhere.
+func Verify(path string, publicKey ed25519.PublicKey, at uint64) (Report, error) {
+	var report Report
+
+	file, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return report, fmt.Errorf("record after %d: malformed: %v", report.Records, err)
+		}
+
+		switch {
+		case record.Sequence != report.Records+1:
+			return report, fmt.Errorf("record %d: expected sequence number %d, records have been removed or reordered", record.Sequence, report.Records+1)
+		case record.PrevHash != report.LastHash:
+			return report, fmt.Errorf("record %d: does not chain to the previous record", record.Sequence)
+		case record.Hash != record.computeHash():
+			return report, fmt.Errorf("record %d: contents do not match its hash, it has been edited", record.Sequence)
+		}
+
+		if record.Event == signatureEvent {
+			signature, err := hex.DecodeString(record.Signature)
+			if err != nil || !ed25519.Verify(publicKey, []byte(record.Hash), signature) {
+				return report, fmt.Errorf("record %d: invalid signature", record.Sequence)
+			}
+			report.Signatures++
+			report.Unsigned = 0
+		} else {
+			report.Unsigned++
+		}
+
+		report.Records = record.Sequence
+		report.LastHash = record.Hash
+		if record.Sequence == at {
+			report.HashAt = record.Hash
+		}
+	}
+
+	return report, scanner.Err()
+}
+
+// LoadOrCreateKey reads the hex encoded signing key seed from a file, generating one if it doesn't exist.
+// The public key is written next to it with a .pub suffix so others can verify the log.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s: invalid signing key", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(privateKey.Seed())+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".pub", []byte(hex.EncodeToString(publicKey)+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	return privateKey, nil
+}
+
+// LoadPublicKey reads a hex encoded public key written by LoadOrCreateKey
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s: invalid public key", path)
+	}
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeLog writes an audit log of the given number of events signed with the key, returning its path
+func writeLog(t *testing.T, path string, key ed25519.PrivateKey, events int, bidder string) string {
+	t.Helper()
+	log, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < events; i++ {
+		if err := log.Append("bid_placed", map[string]string{"bidder": bidder, "amount": strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rewriteLines replaces the lines of a file with those returned by change
+func rewriteLines(t *testing.T, path string, change func(lines []string) []string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := change(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	// 15 events are signed after the 10th and on closing, making 17 records
+	original := writeLog(t, filepath.Join(t.TempDir(), "audit.log"), key, 15, "alice")
+	report, err := Verify(original, publicKey, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 17 || report.Signatures != 2 || report.Unsigned != 0 || report.HashAt != report.LastHash {
+		t.Fatalf("intact log has report %+v", report)
+	}
+	head := report.HashAt
+
+	tests := []struct {
+		name string
+		// Changes a copy of the original log
+		change func(t *testing.T, path string)
+		// Error expected from Verify, if any
+		err string
+		// Whether the log still has the original record 17
+		keepsHead bool
+	}{
+		{
+			name:      "intact",
+			change:    func(t *testing.T, path string) {},
+			keepsHead: true,
+		},
+		{
+			name: "extended",
+			change: func(t *testing.T, path string) {
+				writeLog(t, path, key, 3, "bob")
+			},
+			keepsHead: true,
+		},
+		{
+			// Removing records from the end leaves an intact chain, which only the head recorded elsewhere reveals
+			name: "truncated",
+			change: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines []string) []string { return lines[:12] })
+			},
+		},
+		{
+			name: "rewritten",
+			change: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines []string) []string {
+					lines[4] = strings.Replace(lines[4], `"alice"`, `"mallory"`, 1)
+					return lines
+				})
+			},
+			err: "record 5: contents do not match its hash",
+		},
+		{
+			name: "removed",
+			change: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines []string) []string { return append(lines[:4], lines[5:]...) })
+			},
+			err: "record 6: expected sequence number 5",
+		},
+		{
+			// Anyone with the key can write a different log of the same length that verifies
+			name: "rewritten and signed again",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				writeLog(t, path, key, 15, "mallory")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := os.ReadFile(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "audit.log")
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			test.change(t, path)
+
+			report, err := Verify(path, publicKey, 17)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keepsHead := report.HashAt == head; keepsHead != test.keepsHead {
+				t.Fatalf("log has hash %q at record 17, original had %q", report.HashAt, head)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsOtherKey(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	path := writeLog(t, filepath.Join(t.TempDir(), "audit.log"), key, 3, "alice")
+
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 1
+	other := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	if _, err := Verify(path, other, 0); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("got error %v, want an invalid signature", err)
+	}
+}
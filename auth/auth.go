@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Role grants access to a set of RPC methods
+type Role string
+
+const (
+	// Bidders place bids in their own name
+	RoleBidder Role = "bidder"
+	// Auctioneers start and end auctions
+	RoleAuctioneer Role = "auctioneer"
+	// Observers can only look at results and bid history
+	RoleObserver Role = "observer"
+)
+
+// Issuer of every token, checked when verifying
+const issuer = "auction"
+
+// Claims are the contents of a token. The subject is the name of the bidder or operator.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []Role `json:"roles"`
+}
+
+// HasRole reports whether the token grants any of the given roles
+func (c *Claims) HasRole(roles ...Role) bool {
+	for _, role := range roles {
+		if slices.Contains(c.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRoles parses a comma separated list of roles
+func ParseRoles(list string) ([]Role, error) {
+	var roles []Role
+	for _, name := range strings.Split(list, ",") {
+		role := Role(strings.TrimSpace(strings.ToLower(name)))
+		switch role {
+		case RoleBidder, RoleAuctioneer, RoleObserver:
+			roles = append(roles, role)
+		default:
+			return nil, fmt.Errorf("unknown role %q", name)
+		}
+	}
+	return roles, nil
+}
+
+// Issue creates a token signed with the key, valid for the given duration
+func Issue(key ed25519.PrivateKey, subject string, roles []Role, validFor time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(validFor)),
+		},
+		Roles: roles,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(key)
+}
+
+// Verify checks the signature and expiry of a token and returns its claims
+func Verify(token string, key ed25519.PublicKey) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// Subject returns the subject of a token without verifying it, for clients to know who they are
+func Subject(token string) (string, error) {
+	claims := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// LoadToken reads a token from a file
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Policy maps full gRPC method names to the roles allowed to call them.
+// Methods with no roles can be called without a token, and methods missing from the policy can't be called at all.
+type Policy map[string][]Role
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the verified claims of the caller, if the call was authenticated
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// authorize verifies the bearer token of a call and checks it against the policy,
+// returning a context carrying the claims
+func authorize(ctx context.Context, method string, key ed25519.PublicKey, policy Policy) (context.Context, error) {
+	roles, ok := policy[method]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "method not allowed")
+	}
+	if len(roles) == 0 {
+		return ctx, nil
+	}
+
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	claims, err := Verify(strings.TrimPrefix(values[0], "Bearer "), key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token: "+err.Error())
+	}
+	if !claims.HasRole(roles...) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s requires one of the roles %v", method, roles)
+	}
+	return context.WithValue(ctx, claimsKey{}, claims), nil
+}
+
+// UnaryServerInterceptor rejects unary calls that the caller's token doesn't allow
+func UnaryServerInterceptor(key ed25519.PublicKey, policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorize(ctx, info.FullMethod, key, policy)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streaming calls that the caller's token doesn't allow
+func StreamServerInterceptor(key ed25519.PublicKey, policy Policy) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, err := authorize(stream.Context(), info.FullMethod, key, policy); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// TokenCredentials attach a bearer token to every call
+type TokenCredentials struct {
+	Token string
+
+	// Whether the token may only be sent over TLS
+	RequireTLS bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (c TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.Token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (c TokenCredentials) RequireTransportSecurity() bool {
+	return c.RequireTLS
+}
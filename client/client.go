@@ -3,28 +3,178 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Juules32/Auction/auth"
+	"github.com/Juules32/Auction/config"
+	"github.com/Juules32/Auction/logging"
+	"github.com/Juules32/Auction/metrics"
+	"github.com/Juules32/Auction/money"
 	pb "github.com/Juules32/Auction/proto"
+	"github.com/Juules32/Auction/shards"
+	"github.com/Juules32/Auction/tlsconfig"
+	"github.com/Juules32/Auction/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
-const serverAddr = "localhost:8080"
+var configPath = config.RegisterFlags()
+var serverAddr = flag.String("server-addr", "localhost:8080", "comma separated client addresses of the replicas, of which the client uses whichever serves clients, which is the primary")
+var readAddr = flag.String("read-addr", "", "read address of a replica, e.g. a backup, to get stale results and bid history from (default the server address)")
+var shardsPath = flag.String("shards", "", "JSON file mapping replica group IDs to the client addresses of their replicas, used instead of -server-addr to send calls about each auction to the group hosting it")
+var adminGroup = flag.String("group", "", "replica group to start auctions in, send admin operations to and bid in when no auction is selected (default the first group in the shard map)")
+
+// Bids that time out or can't reach the server are retried with the same request ID
+var bidAttempts = flag.Int("bid-attempts", 5, "attempts made to place a bid before giving up")
+var bidTimeout = flag.Duration("bid-timeout", 2*time.Second, "how long to wait for each bid attempt")
+var bidRetryDelay = flag.Duration("retry-delay", 500*time.Millisecond, "how long to wait before retrying a bid, unless the server says otherwise")
+
+// Name recorded with this client's bids
+var bidder = flag.String("bidder", defaultBidder(), "name to place bids as")
+var logOptions = logging.RegisterFlags()
+var metricsListenAddr = flag.String("metrics-addr", "localhost:0", "address to serve Prometheus metrics on")
+var traceOptions = tracing.RegisterFlags()
+var tlsFiles = tlsconfig.RegisterFlags()
+var tlsServerName = flag.String("tls-server-name", "localhost", "name the server's certificate must be valid for")
+var tokenPath = flag.String("token", "", "file with the token to authenticate with, whose subject is used as the bidder name")
+
+var tracer = otel.Tracer("github.com/Juules32/Auction/client")
+
+var (
+	bidRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_client_bid_retries_total",
+		Help: "Bid attempts retried after a timeout or unavailable server.",
+	})
+
+	bidFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_client_bid_failures_total",
+		Help: "Bids that failed after all attempts.",
+	})
+)
+
+// Logger of the process, including the bidder in every record
+var logger *slog.Logger
+
+// replicaGroup is a connection to the primary of a cluster, along with the latest update seen in its responses,
+// by the term of the primary and sequence number, so answers from a primary that has since been replaced are
+// noticed and reads from other replicas never go back in time
+type replicaGroup struct {
+	id              string
+	conn            *grpc.ClientConn
+	client          pb.AuctionClient
+	readClient      pb.AuctionClient
+	highestTerm     int64
+	highestSequence uint64
+}
+
+// Groups auctions are partitioned across, nil if the client only uses the cluster at -server-addr
+var shardMap *shards.Map
+
+// Connections to the replica groups by ID, made when first used. Without a shard map the cluster at
+// -server-addr is the only group, with an empty ID.
+var groups = make(map[string]*replicaGroup)
+var dialOptions []grpc.DialOption
+
+// Groups auctions were found to have been moved to, by auction ID
+var auctionHosts = make(map[int64]string)
+
+// Auction bids, results and admin operations are about, selected with 'auction <id>',
+// or 0 for the current auction of the admin group
+var selectedAuction int64
 
 func main() {
-	writeToLogAndTerminal("Starting new client...")
+	flag.Parse()
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// The token decides who the client bids as, unless a bidder is given explicitly
+	var token string
+	if *tokenPath != "" {
+		var err error
+		token, err = auth.LoadToken(*tokenPath)
+		if err != nil {
+			log.Fatalf("Error loading token: %v", err)
+		}
+		subject, err := auth.Subject(token)
+		if err != nil {
+			log.Fatalf("Error reading token: %v", err)
+		}
+		if !isFlagSet("bidder") {
+			*bidder = subject
+		}
+	}
+
+	var err error
+	logger, err = logging.New(*logOptions, "client", *bidder+"-"+strconv.Itoa(os.Getpid()))
+	if err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
+	logger = logger.With("bidder", *bidder)
+
+	shutdownTracing, err := tracing.Setup(*traceOptions, "client", *bidder+"-"+strconv.Itoa(os.Getpid()))
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	metricsAddr, err := metrics.Serve(*metricsListenAddr)
+	if err != nil {
+		log.Fatalf("Error serving metrics: %v", err)
+	}
+	logger.Info("Serving metrics on http://"+metricsAddr.String()+"/metrics", "metrics_addr", metricsAddr.String())
+
+	writeToLogAndTerminal("Starting new client for bidder " + *bidder + "...")
+
+	transportCredentials, err := clientTransportCredentials()
+	if err != nil {
+		log.Fatalf("Error loading TLS certificates: %v", err)
+	}
+
+	dialOptions = []grpc.DialOption{grpc.WithTransportCredentials(transportCredentials), grpc.WithStatsHandler(otelgrpc.NewClientHandler())}
+	if token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(auth.TokenCredentials{Token: token}))
+	}
 
-	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	// Other groups are connected to once an auction they host is used
+	admin, err := connect(*adminGroup)
 	if err != nil {
 		log.Fatalf("Error connecting to server: %v", err)
 	}
-	defer conn.Close()
+	defer func() {
+		for _, g := range groups {
+			g.conn.Close()
+		}
+	}()
 
-	client := pb.NewAuctionClient(conn)
+	// Stale results and bid history can be read from another replica, falling back on the primary if it is behind
+	if *readAddr != "" {
+		readConn, err := grpc.Dial(*readAddr, dialOptions...)
+		if err != nil {
+			log.Fatalf("Error connecting to read replica: %v", err)
+		}
+		defer readConn.Close()
+		admin.readClient = pb.NewAuctionClient(readConn)
+	}
 
 	scanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("Enter command:")
@@ -39,42 +189,192 @@ func main() {
 
 		switch strings.ToLower(words[0]) {
 		case "bid":
-			amount, err := strconv.Atoi(words[1])
+			amount, err := money.Parse(strings.Join(words[1:], " "))
 			if err != nil {
-				fmt.Println("Invalid bidding amount!")
+				fmt.Println("Invalid bidding amount:", err)
 				continue
 			}
-			bid(client, int32(amount))
+			bid(amount)
 		case "result":
-			writeToLogAndTerminal("Client queries auction result")
-			result(client)
+			// How up to date the result must be can optionally be given, e.g. 'result linearizable'
+			// Otherwise the server's default is used
+			consistency := pb.ReadConsistency_READ_CONSISTENCY_UNSPECIFIED
+			if len(words) > 1 {
+				value, ok := pb.ReadConsistency_value[strings.ToUpper(words[1])]
+				if !ok || value == int32(pb.ReadConsistency_READ_CONSISTENCY_UNSPECIFIED) {
+					fmt.Println("Invalid consistency, must be 'lease', 'linearizable' or 'stale'")
+					continue
+				}
+				consistency = pb.ReadConsistency(value)
+			}
+			writeToLogAndTerminal("Client queries auction result", "consistency", consistency.String())
+			result(consistency)
+		case "history":
+			// Optionally only shows the bids of one auction, e.g. 'history 2', defaulting to the selected auction
+			auctionId := selectedAuction
+			if len(words) > 1 {
+				auctionId, err = strconv.ParseInt(words[1], 10, 64)
+				if err != nil {
+					fmt.Println("Invalid auction ID!")
+					continue
+				}
+			}
+			history(auctionId)
+		case "start":
+			// The base currency of the auction can optionally be given, e.g. 'start EUR'
+			var currency string
+			if len(words) > 1 {
+				currency = words[1]
+			}
+			startAuction(currency)
+		case "end":
+			endAuction()
+		case "transfer-leadership":
+			// The backup to hand over to can optionally be given, e.g. 'transfer-leadership r2'
+			var replicaId string
+			if len(words) > 1 {
+				replicaId = words[1]
+			}
+			transferLeadership(replicaId)
+		case "add", "remove":
+			// The replica is given by ID, e.g. 'add r4'
+			if len(words) < 2 {
+				fmt.Println("Missing replica ID!")
+				continue
+			}
+			changeMembership(strings.ToLower(words[0]), words[1])
+		case "auction":
+			// Selects the auction to bid in and get results of, e.g. 'auction 3', or the current auction of the
+			// admin group with 'auction 0'
+			if len(words) > 1 {
+				auctionId, err := strconv.ParseInt(words[1], 10, 64)
+				if err != nil || auctionId < 0 {
+					fmt.Println("Invalid auction ID!")
+					continue
+				}
+				selectedAuction = auctionId
+			}
+			if selectedAuction == 0 {
+				fmt.Println("Using the current auction of " + describeGroup(*adminGroup))
+			} else {
+				fmt.Printf("Using auction %d\n", selectedAuction)
+			}
+		case "move":
+			// The selected auction is moved to the group given by ID, e.g. 'move b'
+			if len(words) < 2 {
+				fmt.Println("Missing group ID!")
+				continue
+			}
+			moveAuction(words[1])
 		default:
-			fmt.Println("Invalid command. Valid commands: 'bid', 'result'")
+			fmt.Println("Invalid command. Valid commands: 'bid <amount> <currency>', 'result [lease|linearizable|stale]', 'history [auction id]', 'auction [auction id]', 'start [currency]', 'end', 'move <group id>', 'transfer-leadership [replica id]', 'add <replica id>', 'remove <replica id>'")
 		}
 	}
 }
 
-func bid(client pb.AuctionClient, amount int32) {
-	bidResponse, err := client.Bid(context.Background(), &pb.BidRequest{Amount: amount})
+func bid(amount money.Money) {
+	// The same request ID is used for every attempt so the server applies the bid at most once
+	request := &pb.BidRequest{Amount: amount.Proto(), RequestId: newRequestId(), Bidder: *bidder, AuctionId: selectedAuction}
+	g, err := groupFor(request.AuctionId)
+	if err != nil {
+		fmt.Println("Client bid failed: " + err.Error())
+		return
+	}
+
+	// Every attempt is a child of one span covering the whole bid
+	bidCtx, span := tracer.Start(context.Background(), "bid")
+	span.SetAttributes(attribute.String("auction.bidder", *bidder), attribute.String("auction.request_id", request.RequestId))
+	defer span.End()
+
+	var bidResponse *pb.BidResponse
+	for attempt := 1; attempt <= *bidAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(bidCtx, *bidTimeout)
+		bidResponse, err = g.client.Bid(ctx, request, grpc.WaitForReady(true))
+		cancel()
+
+		// The auction has been moved to another group, which the bid is sent to instead without recording it here
+		if err == nil && bidResponse.Reason == pb.RejectionReason_WRONG_GROUP && attempt < *bidAttempts {
+			writeToLogAndTerminal(fmt.Sprintf("Auction %d has moved to replica group %s, bidding there", bidResponse.AuctionId, bidResponse.Group), "auction", bidResponse.AuctionId, "group", bidResponse.Group, "request_id", request.RequestId)
+			request.AuctionId = bidResponse.AuctionId
+			if g, err = follow(bidResponse.AuctionId, bidResponse.Group); err != nil {
+				break
+			}
+			continue
+		}
+
+		// A primary that has stepped down rejects the bid without recording it, so it is retried with the new primary
+		if err == nil && bidResponse.Reason == pb.RejectionReason_NOT_LEADER && attempt < *bidAttempts {
+			bidRetriesTotal.Inc()
+			fmt.Printf("Client bid attempt %d reached a replica that is not the primary, retrying...\n", attempt)
+			logger.Warn("Client bid attempt reached a replica that is not the primary, retrying", "attempt", attempt, "request_id", request.RequestId, "term", bidResponse.Term)
+			time.Sleep(*bidRetryDelay)
+			continue
+		}
+		if err == nil || !isRetryable(err) {
+			break
+		}
+		bidRetriesTotal.Inc()
+		fmt.Printf("Client bid attempt %d failed (%s), retrying...\n", attempt, describeError(err))
+		logger.Warn("Client bid attempt failed, retrying", "attempt", attempt, "request_id", request.RequestId, "error", err)
+		time.Sleep(retryDelay(err))
+	}
 	if err != nil {
-		log.Fatalf("Error bidding: %v", err)
+		bidFailuresTotal.Inc()
+		fmt.Println("Client bid failed: " + describeError(err))
+		logger.Error("Client bid failed", "request_id", request.RequestId, "error", err)
+		return
+	}
+
+	g.observe(bidResponse.Term, bidResponse.Sequence)
+
+	// Shows what the bid was worth in the auction's base currency if it was converted
+	message := bidResponse.Message
+	if !bidResponse.Success {
+		message = describeRejection(bidResponse)
+	}
+	converted := money.FromProto(bidResponse.ConvertedAmount)
+	if bidResponse.ConvertedAmount != nil && converted.Currency != amount.Currency {
+		message += " (" + amount.String() + " = " + converted.String() + " at " + bidResponse.ExchangeRate + ")"
 	}
 
 	if bidResponse.Success {
-		writeToLogAndTerminal("Client bid successfully: " + bidResponse.Message)
+		writeToLogAndTerminal("Client bid successfully: "+message, "request_id", request.RequestId)
 	} else {
-		writeToLogAndTerminal("Client bid failed: " + bidResponse.Message)
+		writeToLogAndTerminal("Client bid failed: "+message, "request_id", request.RequestId, "reason", bidResponse.Reason.String())
 	}
 }
 
-func result(client pb.AuctionClient) {
-	resultResponse, err := client.Result(context.Background(), &pb.ResultRequest{})
+// result prints the highest bid of the selected auction, following it to the group it was moved to
+func result(consistency pb.ReadConsistency) {
+	auctionId := selectedAuction
+	g, err := groupFor(auctionId)
+	var resultResponse *pb.ResultResponse
+	for redirects := 0; err == nil; redirects++ {
+		resultResponse, err = g.result(consistency, auctionId)
+		moved, group := movedTo(err)
+		if moved == 0 || redirects == maxRedirects() {
+			break
+		}
+		auctionId = moved
+		g, err = follow(moved, group)
+	}
 	if err != nil {
-		log.Fatalf("Error getting result: %v", err)
+		fmt.Println("Error getting result: " + describeError(err))
+		logger.Error("Error getting result", "error", err)
+		return
+	}
+
+	if !g.observe(resultResponse.Term, resultResponse.Sequence) {
+		writeToLogAndTerminal(fmt.Sprintf("Result is from a replaced primary of term %d, term %d has started since", resultResponse.Term, g.highestTerm), "term", resultResponse.Term, "highest_term", g.highestTerm)
 	}
 
 	if resultResponse.IsActive {
-		writeToLogAndTerminal("Highest Bid: " + strconv.Itoa(int(resultResponse.HighestBid)))
+		conversion := money.Conversion{
+			Original:  money.FromProto(resultResponse.HighestBidOriginal),
+			Converted: money.FromProto(resultResponse.HighestBid),
+			Rate:      resultResponse.ExchangeRate,
+		}
+		writeToLogAndTerminal("Highest Bid: "+conversion.String(), "auction", resultResponse.AuctionId)
 
 	} else {
 		writeToLogAndTerminal("There is no active auction")
@@ -82,16 +382,477 @@ func result(client pb.AuctionClient) {
 
 }
 
-func writeToLogAndTerminal(message string) {
-	fmt.Println(message)
+// result gets the result of an auction from the group, getting stale results from the read replica
+func (g *replicaGroup) result(consistency pb.ReadConsistency, auctionId int64) (*pb.ResultResponse, error) {
+	request := &pb.ResultRequest{Consistency: consistency, MinTerm: g.highestTerm, MinSequence: g.highestSequence, AuctionId: auctionId}
+	readClient := g.readClient
+	if consistency != pb.ReadConsistency_STALE {
+		readClient = g.client
+	}
+
+	response, err := readClient.Result(context.Background(), request)
+	if readClient != g.client && errorReason(err) == "REPLICA_BEHIND" {
+		writeToLogAndTerminal("Read replica is behind, asking the primary instead")
+		response, err = g.client.Result(context.Background(), request)
+	}
+	return response, err
+}
+
+// history prints every recorded bid attempt, fetching the ledger one page at a time. The bids of an auction are
+// listed by the group hosting it, while without an auction the ledger of the admin group is listed.
+func history(auctionId int64) {
+	g, err := groupFor(auctionId)
+	if err != nil {
+		fmt.Println("Error listing bids: " + err.Error())
+		return
+	}
+	request := &pb.ListBidsRequest{AuctionId: auctionId, MinTerm: g.highestTerm, MinSequence: g.highestSequence}
+	readClient := g.readClient
+	count := 0
+	for redirects := 0; ; {
+		response, err := readClient.ListBids(context.Background(), request)
+		if readClient != g.client && errorReason(err) == "REPLICA_BEHIND" {
+			writeToLogAndTerminal("Read replica is behind, asking the primary instead")
+			readClient = g.client
+			response, err = g.client.ListBids(context.Background(), request)
+		}
+		if moved, group := movedTo(err); moved != 0 && redirects < maxRedirects() {
+			redirects++
+			if g, err = follow(moved, group); err == nil {
+				request.MinTerm, request.MinSequence = g.highestTerm, g.highestSequence
+				readClient = g.readClient
+				continue
+			}
+		}
+		if err != nil {
+			fmt.Println("Error listing bids: " + describeError(err))
+			logger.Error("Error listing bids", "error", err)
+			return
+		}
+
+		g.observe(response.Term, response.Sequence)
+		for _, record := range response.Bids {
+			fmt.Println(describeBidRecord(record))
+			count++
+		}
+
+		if response.NextPageToken == "" {
+			break
+		}
+		request.PageToken = response.NextPageToken
+	}
+
+	if count == 0 {
+		fmt.Println("No bids have been placed")
+	}
+}
+
+// describeBidRecord formats a ledger entry like
+// "#3 auction 1 at 11:40:03 alice bid 12.50 EUR (13.59 USD at 1.087): accepted"
+func describeBidRecord(record *pb.BidRecord) string {
+	conversion := money.Conversion{
+		Original:  money.FromProto(record.Amount),
+		Converted: money.FromProto(record.ConvertedAmount),
+		Rate:      record.ExchangeRate,
+	}
+	if record.ConvertedAmount == nil {
+		conversion.Converted = conversion.Original
+	}
+
+	outcome := "accepted"
+	if !record.Accepted {
+		outcome = "rejected (" + strings.ToLower(strings.ReplaceAll(record.Reason.String(), "_", " ")) + ")"
+	}
+
+	bidderName := record.Bidder
+	if bidderName == "" {
+		bidderName = "anonymous"
+	}
+
+	return fmt.Sprintf("#%d auction %d at %s %s bid %s: %s",
+		record.Sequence, record.AuctionId, record.Timestamp.AsTime().Local().Format(time.DateTime), bidderName, conversion, outcome)
+}
+
+// startAuction asks the admin group to start a new auction, which requires the auctioneer role
+func startAuction(currency string) {
+	g, err := groupFor(0)
+	if err != nil {
+		fmt.Println("Error starting auction: " + err.Error())
+		return
+	}
+	response, err := g.client.StartAuction(context.Background(), &pb.StartAuctionRequest{BaseCurrency: currency})
+	if err != nil {
+		fmt.Println("Error starting auction: " + describeError(err))
+		logger.Error("Error starting auction", "error", err)
+		return
+	}
+	g.observe(response.Term, response.Sequence)
+	writeToLogAndTerminal(fmt.Sprintf("Started auction %d for %s starting at %s", response.AuctionId, response.ItemName, money.FromProto(response.MinimumBid)), "auction", response.AuctionId)
+}
+
+// endAuction asks the group hosting the selected auction to end its current auction, which requires the auctioneer role
+func endAuction() {
+	g, err := groupFor(selectedAuction)
+	if err != nil {
+		fmt.Println("Error ending auction: " + err.Error())
+		return
+	}
+	response, err := g.client.EndAuction(context.Background(), &pb.EndAuctionRequest{})
+	if err != nil {
+		fmt.Println("Error ending auction: " + describeError(err))
+		logger.Error("Error ending auction", "error", err)
+		return
+	}
+	g.observe(response.Term, response.Sequence)
+	conversion := money.Conversion{
+		Original:  money.FromProto(response.WinningBidOriginal),
+		Converted: money.FromProto(response.WinningBid),
+		Rate:      response.ExchangeRate,
+	}
+	writeToLogAndTerminal(fmt.Sprintf("Ended auction %d with winning bid %s", response.AuctionId, conversion), "auction", response.AuctionId)
+}
+
+// transferLeadership asks the primary of the admin group to hand over to a backup, which requires the auctioneer role
+func transferLeadership(replicaId string) {
+	g, err := groupFor(0)
+	if err != nil {
+		fmt.Println("Error transferring leadership: " + err.Error())
+		return
+	}
+	response, err := g.client.TransferLeadership(context.Background(), &pb.TransferLeadershipRequest{ReplicaId: replicaId})
+	if err != nil {
+		fmt.Println("Error transferring leadership: " + describeError(err))
+		logger.Error("Error transferring leadership", "error", err)
+		return
+	}
+	writeToLogAndTerminal(fmt.Sprintf("Leadership of term %d was handed over to replica %s", response.Term, response.ReplicaId), "replica", response.ReplicaId, "term", response.Term)
+}
+
+// changeMembership asks the primary of the admin group to add a replica to the cluster or remove one,
+// which requires the auctioneer role
+func changeMembership(operation string, replicaId string) {
+	g, err := groupFor(0)
+	if err != nil {
+		fmt.Println("Error changing the replicas of the cluster: " + err.Error())
+		return
+	}
+	var response *pb.MembershipResponse
+	if operation == "add" {
+		response, err = g.client.AddReplica(context.Background(), &pb.AddReplicaRequest{ReplicaId: replicaId})
+	} else {
+		response, err = g.client.RemoveReplica(context.Background(), &pb.RemoveReplicaRequest{ReplicaId: replicaId})
+	}
+	if err != nil {
+		fmt.Println("Error changing the replicas of the cluster: " + describeError(err))
+		logger.Error("Error changing the replicas of the cluster", "operation", operation, "replica", replicaId, "error", err)
+		return
+	}
+	g.observe(response.Term, response.Sequence)
+
+	var members []string
+	for _, member := range response.Members {
+		members = append(members, member.ReplicaId+"="+member.Address)
+	}
+	writeToLogAndTerminal("The replicas of the cluster are now "+strings.Join(members, ", "), "operation", operation, "replica", replicaId, "term", response.Term)
+}
+
+// moveAuction asks the group hosting the selected auction to move it to another group, which requires the auctioneer role
+func moveAuction(group string) {
+	g, err := groupFor(selectedAuction)
+	if err != nil {
+		fmt.Println("Error moving auction: " + err.Error())
+		return
+	}
+	response, err := g.client.MoveAuction(context.Background(), &pb.MoveAuctionRequest{AuctionId: selectedAuction, Group: group})
+	if err != nil {
+		fmt.Println("Error moving auction: " + describeError(err))
+		logger.Error("Error moving auction", "auction", selectedAuction, "group", group, "error", err)
+		return
+	}
+	g.observe(response.Term, response.Sequence)
+	auctionHosts[response.AuctionId] = response.Group
+	writeToLogAndTerminal(fmt.Sprintf("Moved auction %d to replica group %s", response.AuctionId, response.Group), "auction", response.AuctionId, "group", response.Group)
+}
+
+// observe records the update a response of the group reflects, returning false if it is of an older term than one
+// already seen. Responses rejected before reaching the auction have no term and are ignored.
+func (g *replicaGroup) observe(term int64, sequence uint64) bool {
+	switch {
+	case term == 0:
+	case term < g.highestTerm:
+		return false
+	case term > g.highestTerm:
+		g.highestTerm, g.highestSequence = term, sequence
+	default:
+		g.highestSequence = max(g.highestSequence, sequence)
+	}
+	return true
+}
+
+// groupFor returns the group hosting an auction, which is the group that started it unless it was found to have
+// been moved, or the admin group for auction 0
+func groupFor(auctionId int64) (*replicaGroup, error) {
+	id := *adminGroup
+	if shardMap != nil && auctionId != 0 {
+		id = shardMap.Owner(auctionId)
+		if host, ok := auctionHosts[auctionId]; ok {
+			id = host
+		}
+	}
+	return connect(id)
+}
+
+// follow remembers the group an auction was moved to, returning the group
+func follow(auctionId int64, group string) (*replicaGroup, error) {
+	auctionHosts[auctionId] = group
+	return connect(group)
+}
+
+// connect returns the connection to a group, connecting to its replicas the first time
+func connect(id string) (*replicaGroup, error) {
+	if g, ok := groups[id]; ok {
+		return g, nil
+	}
+
+	addrs := config.SplitList(*serverAddr)
+	if shardMap != nil {
+		var ok bool
+		addrs, ok = shardMap.Addresses(id)
+		if !ok {
+			return nil, errors.New("replica group " + id + " is not in the shard map")
+		}
+	}
+	conn, err := shards.Dial(addrs, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := pb.NewAuctionClient(conn)
+	g := &replicaGroup{id: id, conn: conn, client: client, readClient: client}
+	groups[id] = g
+	return g, nil
+}
+
+// movedTo returns the auction and the group it was moved to if a call failed with WRONG_GROUP, or 0 otherwise
+func movedTo(err error) (int64, string) {
+	for _, detail := range status.Convert(err).Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok && errorInfo.Reason == "WRONG_GROUP" {
+			auctionId, _ := strconv.ParseInt(errorInfo.Metadata["auction_id"], 10, 64)
+			return auctionId, errorInfo.Metadata["group"]
+		}
+	}
+	return 0, ""
+}
+
+// maxRedirects is how often a call follows an auction from group to group, which is never more than there are groups
+func maxRedirects() int {
+	if shardMap == nil {
+		return 0
+	}
+	return len(shardMap.Groups())
+}
+
+// describeGroup names a group for the terminal
+func describeGroup(id string) string {
+	if id == "" {
+		return "the server"
+	}
+	return "replica group " + id
+}
+
+// errorReason returns the reason given in the details of a failed call, if any
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			return errorInfo.Reason
+		}
+	}
+	return ""
+}
+
+// loadConfig applies the config file and environment variables to the flags and validates the result
+func loadConfig() error {
+	if err := config.Load(*configPath); err != nil {
+		return err
+	}
+
+	errs := []error{
+		config.CheckAddress("metrics-addr", *metricsListenAddr),
+		config.CheckPositive("bid-attempts", *bidAttempts),
+		config.CheckPositive("bid-timeout", *bidTimeout),
+		config.CheckPositive("retry-delay", *bidRetryDelay),
+	}
+	for _, addr := range config.SplitList(*serverAddr) {
+		errs = append(errs, config.CheckAddress("server-addr", addr))
+	}
+	if len(config.SplitList(*serverAddr)) == 0 {
+		errs = append(errs, errors.New("-server-addr must list at least one address"))
+	}
+	if err := loadShards(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// loadShards reads the shard map if one is given, defaulting the admin group to its first group
+func loadShards() error {
+	if *shardsPath == "" {
+		if *adminGroup != "" {
+			return errors.New("-group requires -shards")
+		}
+		return nil
+	}
+	if *readAddr != "" {
+		return errors.New("-read-addr can't be used with -shards, as it is the read address of a single group")
+	}
+
+	var err error
+	shardMap, err = shards.Load(*shardsPath)
+	if err != nil {
+		return errors.New("-shards: " + err.Error())
+	}
+	if *adminGroup == "" {
+		*adminGroup = shardMap.Groups()[0]
+	}
+	if _, ok := shardMap.Addresses(*adminGroup); !ok {
+		return errors.New("-group " + *adminGroup + " is not one of the groups in the shard map")
+	}
+	return nil
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	f, err := os.OpenFile("log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+// defaultBidder names the bidder after the logged in user
+func defaultBidder() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+// clientTransportCredentials verifies the server with TLS when a CA is configured
+func clientTransportCredentials() (credentials.TransportCredentials, error) {
+	if tlsFiles.CA == "" && tlsFiles.Cert == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	reloader, err := tlsconfig.NewReloader(*tlsFiles)
 	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+		return nil, err
+	}
+	reloader.OnReloadError = func(err error) {
+		logger.Error("Error reloading TLS certificates, keeping the previous ones", "error", err)
+	}
+	return credentials.NewTLS(reloader.ClientConfig(*tlsServerName)), nil
+}
+
+// newRequestId generates a random ID identifying a bid across retries
+func newRequestId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatalf("Error generating request ID: %v", err)
 	}
-	log.SetOutput(f)
+	return hex.EncodeToString(id)
+}
 
-	log.Println(message)
+// isRetryable reports whether a failed call may not have reached the primary replica,
+// or the server has asked for the call to be retried
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
 
-	defer f.Close()
+// retryDelay returns how long to wait before retrying, as requested by the server if it says so
+func retryDelay(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok && retryInfo.RetryDelay != nil {
+			return retryInfo.RetryDelay.AsDuration()
+		}
+	}
+	return *bidRetryDelay
+}
+
+// describeError explains a failed call, including the reason given in the error details
+func describeError(err error) string {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			switch errorInfo.Reason {
+			case "FX_RATES_UNAVAILABLE":
+				return "the server's exchange rates are unavailable, try again later or bid in the auction's base currency"
+			case "RATE_LIMITED":
+				return "you are sending too many requests, please wait a moment"
+			case "LEASE_EXPIRED":
+				return "the primary can't be sure it is still primary, try again shortly or ask for a 'stale' result"
+			case "LEADERSHIP_UNCONFIRMED":
+				return "the primary could not confirm it is still primary with a quorum of replicas"
+			case "NOT_COMMITTED":
+				return "the primary could not replicate the change to a quorum of replicas, so it may have been lost, try again shortly"
+			case "REPLICA_BEHIND":
+				return "the replica hasn't caught up with what you have already seen, try again shortly"
+			case "WRONG_GROUP":
+				return "the auction has moved on to replica group " + errorInfo.Metadata["group"] + ", please try again"
+			default:
+				return errorInfo.Reason + ": " + st.Message()
+			}
+		}
+	}
+
+	switch st.Code() {
+	case codes.Unauthenticated:
+		return "not authenticated, a valid token must be given with -token (" + st.Message() + ")"
+	case codes.PermissionDenied:
+		return "not allowed: " + st.Message()
+	case codes.Unavailable:
+		return "the auction server is unavailable"
+	case codes.DeadlineExceeded:
+		return "the auction server did not respond in time"
+	default:
+		return st.Code().String() + ": " + st.Message()
+	}
+}
+
+// describeRejection explains why a bid was rejected
+func describeRejection(response *pb.BidResponse) string {
+	switch response.Reason {
+	case pb.RejectionReason_AUCTION_INACTIVE:
+		return "There is no active auction to bid on"
+	case pb.RejectionReason_TOO_LOW:
+		return "Your bid must be higher than the current highest bid, use 'result' to see it"
+	case pb.RejectionReason_BELOW_MINIMUM:
+		return response.Message + ", please bid at least that much"
+	case pb.RejectionReason_NOT_LEADER:
+		return "The replica reached is not the primary, please try again shortly"
+	case pb.RejectionReason_DUPLICATE:
+		return "This bid was already submitted with a different amount"
+	case pb.RejectionReason_RATE_LIMITED:
+		return "You are bidding too often, please wait a moment"
+	case pb.RejectionReason_INVALID_AMOUNT:
+		return "Bids must be a positive amount"
+	case pb.RejectionReason_UNSUPPORTED_CURRENCY:
+		return "That currency can't be used in this auction (" + response.Message + ")"
+	case pb.RejectionReason_WRONG_GROUP:
+		return "The auction has moved to replica group " + response.Group + ", please try again"
+	default:
+		return response.Message
+	}
+}
+
+// writeToLogAndTerminal prints the message and logs it along with any additional key-value fields
+func writeToLogAndTerminal(message string, fields ...any) {
+	fmt.Println(message)
+	logger.Info(message, fields...)
 }
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the environment variables setting flags, e.g. AUCTION_CLIENT_ADDR for -client-addr
+const EnvPrefix = "AUCTION_"
+
+// Name of the flag holding the path of the config file
+const configFlag = "config"
+
+// RegisterFlags adds the flag for the config file to the command line
+func RegisterFlags() *string {
+	return flag.String(configFlag, "", "JSON file with values for any of the flags, keyed by flag name. Flags given on the command line take precedence, followed by "+EnvPrefix+" environment variables")
+}
+
+// EnvName returns the environment variable setting a flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load sets every flag that wasn't given on the command line from its environment variable,
+// or otherwise from the config file at path, if any. Must be called after flag.Parse.
+// The config file itself can also be given with the AUCTION_CONFIG environment variable.
+func Load(path string) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if path == "" {
+		path = os.Getenv(EnvName(configFlag))
+	}
+	settings, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	flag.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || f.Name == configFlag {
+			return
+		}
+
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err := flag.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s=%q: %v", EnvName(f.Name), value, err))
+			}
+			return
+		}
+
+		if value, ok := settings[f.Name]; ok {
+			if err := flag.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: setting %q to %q: %v", path, f.Name, value, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// readFile reads the settings of a config file as the strings they would be given as on the command line
+func readFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	settings := make(map[string]string, len(raw))
+	var errs []error
+	for name, value := range raw {
+		if flag.Lookup(name) == nil || name == configFlag {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, name))
+			continue
+		}
+		text, err := settingString(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: setting %q: %v", path, name, err))
+			continue
+		}
+		settings[name] = text
+	}
+	return settings, errors.Join(errs...)
+}
+
+// settingString converts a JSON value to a flag value, joining lists with commas
+func settingString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, ok := item.(string)
+			if !ok {
+				return "", errors.New("lists may only contain strings")
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// CheckAddress validates a host:port address, naming the flag it was given with in the error
+func CheckAddress(flagName string, address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("-%s: %v", flagName, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("-%s: invalid port in address %q", flagName, address)
+	}
+	return nil
+}
+
+// CheckPositive validates that a numeric setting is above zero, naming the flag it was given with in the error
+func CheckPositive[T ~int | ~int64 | ~float64](flagName string, value T) error {
+	if value <= 0 {
+		return fmt.Errorf("-%s: must be positive, got %v", flagName, value)
+	}
+	return nil
+}
+
+// SplitList splits a comma separated flag value, ignoring empty items
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Generates a local development CA and a certificate for the replicas signed by it.
+// The replica certificate is valid both for serving clients and for mutual TLS between replicas.
+func main() {
+	outDir := flag.String("out", "certs", "directory to write the certificates to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "comma separated host names and IP addresses the replica certificate is valid for")
+	validFor := flag.Duration("valid-for", 365*24*time.Hour, "how long the certificates are valid")
+	flag.Parse()
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("Error creating %s: %v", *outDir, err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(*validFor)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("Error generating CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "Auction development CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		log.Fatalf("Error creating CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	replicaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("Error generating replica key: %v", err)
+	}
+	replicaTemplate := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: "Auction replica"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range strings.Split(*hosts, ",") {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); ip != nil {
+			replicaTemplate.IPAddresses = append(replicaTemplate.IPAddresses, ip)
+		} else if host != "" {
+			replicaTemplate.DNSNames = append(replicaTemplate.DNSNames, host)
+		}
+	}
+	replicaDER, err := x509.CreateCertificate(rand.Reader, replicaTemplate, caCert, &replicaKey.PublicKey, caKey)
+	if err != nil {
+		log.Fatalf("Error creating replica certificate: %v", err)
+	}
+
+	writePEM(filepath.Join(*outDir, "ca.pem"), "CERTIFICATE", caDER, 0644)
+	writeKey(filepath.Join(*outDir, "ca-key.pem"), caKey)
+	writePEM(filepath.Join(*outDir, "replica.pem"), "CERTIFICATE", replicaDER, 0644)
+	writeKey(filepath.Join(*outDir, "replica-key.pem"), replicaKey)
+
+	fmt.Println("Wrote development certificates to " + *outDir)
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		log.Fatalf("Error generating serial number: %v", err)
+	}
+	return serial
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		log.Fatalf("Error encoding key: %v", err)
+	}
+	writePEM(path, "PRIVATE KEY", der, 0600)
+}
+
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		log.Fatalf("Error writing %s: %v", path, err)
+	}
+}
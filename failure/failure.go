@@ -0,0 +1,143 @@
+package failure
+
+import (
+	"math"
+	"time"
+)
+
+// Detector decides whether a monitored process has failed from the arrival times of its heartbeats.
+// Detectors are not safe for concurrent use.
+type Detector interface {
+	// Heartbeat records that the process was heard from at the given time
+	Heartbeat(at time.Time)
+	// Suspicion returns how strongly the process is suspected of having failed at the given time,
+	// on a scale where Failed reports failure at 1 for timeouts and at the threshold for phi accrual
+	Suspicion(now time.Time) float64
+	// Failed reports whether the process is considered to have failed at the given time
+	Failed(now time.Time) bool
+}
+
+// TimeoutDetector considers the process failed once no heartbeat has arrived within a fixed timeout
+type TimeoutDetector struct {
+	timeout time.Duration
+	last    time.Time
+}
+
+// NewTimeoutDetector creates a timeout detector, counting from now as if a heartbeat had just arrived
+func NewTimeoutDetector(timeout time.Duration) *TimeoutDetector {
+	return &TimeoutDetector{timeout: timeout, last: time.Now()}
+}
+
+// Heartbeat implements Detector
+func (d *TimeoutDetector) Heartbeat(at time.Time) {
+	d.last = at
+}
+
+// Suspicion implements Detector, returning the time since the last heartbeat as a fraction of the timeout
+func (d *TimeoutDetector) Suspicion(now time.Time) float64 {
+	return float64(now.Sub(d.last)) / float64(d.timeout)
+}
+
+// Failed implements Detector
+func (d *TimeoutDetector) Failed(now time.Time) bool {
+	return d.Suspicion(now) >= 1
+}
+
+// Number of heartbeat intervals the phi accrual detector estimates the distribution from
+const phiWindowSize = 100
+
+// PhiAccrualDetector estimates the distribution of heartbeat intervals and expresses suspicion as phi,
+// the negative base 10 logarithm of the probability that a heartbeat would still arrive this late.
+// A threshold of 8 means the detector is wrong about one in 10^8 times, assuming normally distributed
+// intervals, and adapts to networks where heartbeats are regularly delayed.
+type PhiAccrualDetector struct {
+	threshold float64
+
+	// Added to the mean interval, so pauses such as garbage collection or a slow network are tolerated
+	acceptablePause time.Duration
+	// Lower bound of the standard deviation, so very regular heartbeats don't make the detector overly sensitive
+	minStdDev time.Duration
+
+	intervals []time.Duration
+	last      time.Time
+}
+
+// NewPhiAccrualDetector creates a phi accrual detector for heartbeats expected at the given interval,
+// counting from now as if a heartbeat had just arrived
+func NewPhiAccrualDetector(threshold float64, expectedInterval time.Duration, acceptablePause time.Duration) *PhiAccrualDetector {
+	d := &PhiAccrualDetector{
+		threshold:       threshold,
+		acceptablePause: acceptablePause,
+		minStdDev:       minStdDev(expectedInterval),
+		last:            time.Now(),
+	}
+
+	// Starts with a guess of the distribution until real intervals have been seen
+	d.intervals = []time.Duration{expectedInterval - expectedInterval/4, expectedInterval + expectedInterval/4}
+	return d
+}
+
+// minStdDev returns the lower bound of the standard deviation of heartbeats expected at the given interval
+func minStdDev(expectedInterval time.Duration) time.Duration {
+	return expectedInterval / 10
+}
+
+// Heartbeat implements Detector
+func (d *PhiAccrualDetector) Heartbeat(at time.Time) {
+	if interval := at.Sub(d.last); interval > 0 {
+		d.intervals = append(d.intervals, interval)
+		if len(d.intervals) > phiWindowSize {
+			d.intervals = d.intervals[len(d.intervals)-phiWindowSize:]
+		}
+	}
+	d.last = at
+}
+
+// Suspicion implements Detector, returning phi
+func (d *PhiAccrualDetector) Suspicion(now time.Time) float64 {
+	var sum, sumOfSquares float64
+	for _, interval := range d.intervals {
+		sum += float64(interval)
+		sumOfSquares += float64(interval) * float64(interval)
+	}
+	n := float64(len(d.intervals))
+	mean := sum/n + float64(d.acceptablePause)
+	stdDev := math.Max(math.Sqrt(math.Max(sumOfSquares/n-(sum/n)*(sum/n), 0)), float64(d.minStdDev))
+
+	return phi((float64(now.Sub(d.last)) - mean) / stdDev)
+}
+
+// phi returns the suspicion of a heartbeat that is y standard deviations later than the mean interval,
+// using a logistic approximation of the cumulative normal distribution
+func phi(y float64) float64 {
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if y > 0 {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+// EarliestPhiFailure returns the shortest silence after which a phi accrual detector with the given settings
+// can consider the process failed. That is when heartbeats have arrived in quick succession, as with a steady
+// stream of updates, so the mean interval is close to zero and the standard deviation at its lower bound.
+func EarliestPhiFailure(threshold float64, expectedInterval time.Duration, acceptablePause time.Duration) time.Duration {
+	// phi grows with y, so the y at which it reaches the threshold is found by bisection
+	low, high := 0.0, 1.0
+	for phi(high) < threshold {
+		high *= 2
+	}
+	for i := 0; i < 64; i++ {
+		mid := (low + high) / 2
+		if phi(mid) < threshold {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return acceptablePause + time.Duration(low*float64(minStdDev(expectedInterval)))
+}
+
+// Failed implements Detector
+func (d *PhiAccrualDetector) Failed(now time.Time) bool {
+	return d.Suspicion(now) >= d.threshold
+}
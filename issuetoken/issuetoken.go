@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Juules32/Auction/audit"
+	"github.com/Juules32/Auction/auth"
+)
+
+// Issues a signed token granting roles to a bidder or operator and prints it.
+// The signing key is created on first use, and replicas verify tokens with its public key.
+func main() {
+	keyPath := flag.String("key", "auth.key", "signing key, created with a .pub public key next to it if it doesn't exist")
+	subject := flag.String("subject", "", "name of the bidder or operator the token is for")
+	roles := flag.String("roles", string(auth.RoleBidder), "comma separated roles: bidder, auctioneer and observer")
+	validFor := flag.Duration("valid-for", 24*time.Hour, "how long the token is valid")
+	flag.Parse()
+
+	if *subject == "" {
+		log.Fatalf("A subject must be given with -subject")
+	}
+	parsedRoles, err := auth.ParseRoles(*roles)
+	if err != nil {
+		log.Fatalf("Invalid roles: %v", err)
+	}
+
+	key, err := audit.LoadOrCreateKey(*keyPath)
+	if err != nil {
+		log.Fatalf("Error loading signing key: %v", err)
+	}
+
+	token, err := auth.Issue(key, *subject, parsedRoles, *validFor)
+	if err != nil {
+		log.Fatalf("Error signing token: %v", err)
+	}
+	fmt.Println(token)
+}
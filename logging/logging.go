@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Directory holding the log files of every process unless another destination is given
+const defaultDirectory = "logs"
+
+// Options configure where and what a process logs
+type Options struct {
+	Destination string
+	Level       string
+	MaxSizeMB   int
+	MaxBackups  int
+}
+
+// RegisterFlags adds flags for the logging options to the command line
+func RegisterFlags() *Options {
+	options := &Options{}
+	flag.StringVar(&options.Destination, "log", "", "file to write JSON logs to, '-' for stderr (default logs/<process>-<id>.log)")
+	flag.StringVar(&options.Level, "log-level", "info", "minimum level to log: debug, info, warn or error")
+	flag.IntVar(&options.MaxSizeMB, "log-max-size", 10, "size in megabytes at which the log file is rotated")
+	flag.IntVar(&options.MaxBackups, "log-max-backups", 5, "number of rotated log files to keep")
+	return options
+}
+
+// New creates a JSON logger for the process, writing to a rotated file unless logging to stderr.
+// Every record includes the process and id fields.
+func New(options Options, process string, id string) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(options.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", options.Level)
+	}
+
+	destination := options.Destination
+	if destination == "" {
+		destination = filepath.Join(defaultDirectory, process+"-"+sanitize(id)+".log")
+	}
+
+	var writer io.Writer = os.Stderr
+	if destination != "-" {
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return nil, err
+		}
+		writer = &lumberjack.Logger{
+			Filename:   destination,
+			MaxSize:    options.MaxSizeMB,
+			MaxBackups: options.MaxBackups,
+		}
+	}
+
+	handler := slog.NewJSONHandler(writer, &slog.HandlerOptions{Level: level})
+	return slog.New(handler).With("process", process, "id", id), nil
+}
+
+// sanitize makes an id safe to use in a file name
+func sanitize(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, id)
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Serve exposes the registered Prometheus metrics at /metrics on the given address,
+// returning the address actually listened on so a port of 0 can be used
+func Serve(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.Serve(listener, mux)
+
+	return listener.Addr(), nil
+}
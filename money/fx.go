@@ -0,0 +1,139 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// RateTable holds exchange rates relative to a reference currency,
+// i.e. one unit of Base is worth Rates[currency] units of that currency
+type RateTable struct {
+	Base  string
+	Rates map[string]*big.Rat
+}
+
+// Conversion records an amount converted at a snapshotted exchange rate
+type Conversion struct {
+	Original  Money  `json:"Original"`
+	Converted Money  `json:"Converted"`
+	Rate      string `json:"Rate"`
+}
+
+// Layout of the exchange rate file, e.g. {"base": "USD", "rates": {"EUR": 0.92}}
+type rateFile struct {
+	Base  string                 `json:"base"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+// LoadRates reads an exchange rate table from a JSON file
+func LoadRates(path string) (*RateTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file rateFile
+	decoder := json.NewDecoder(f)
+	decoder.UseNumber()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", path, err)
+	}
+
+	base, err := NormalizeCurrency(file.Base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: base: %v", path, err)
+	}
+
+	table := &RateTable{Base: base, Rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for code, number := range file.Rates {
+		currency, err := NormalizeCurrency(code)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		rate, ok := new(big.Rat).SetString(number.String())
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("%s: invalid rate %s for %s", path, number, currency)
+		}
+		table.Rates[currency] = rate
+	}
+
+	return table, nil
+}
+
+// Rate returns how many units of the target currency one unit of the source currency is worth
+func (t *RateTable) Rate(from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no exchange rates loaded to convert %s to %s", from, to)
+	}
+
+	fromRate, ok := t.Rates[from]
+	if !ok {
+		return nil, fmt.Errorf("no exchange rate for %s", from)
+	}
+	toRate, ok := t.Rates[to]
+	if !ok {
+		return nil, fmt.Errorf("no exchange rate for %s", to)
+	}
+
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+// Convert converts an amount into the target currency, rounding to the nearest minor unit
+func (t *RateTable) Convert(m Money, to string) (Conversion, error) {
+	rate, err := t.Rate(m.Currency, to)
+	if err != nil {
+		return Conversion{}, err
+	}
+
+	// Minor units of the target = minor units of the source * rate, adjusted for differing exponents
+	units := new(big.Rat).SetInt64(m.Units)
+	units.Mul(units, rate)
+	units.Mul(units, new(big.Rat).SetFrac64(Scale(to), Scale(m.Currency)))
+
+	converted, err := roundRat(units)
+	if err != nil {
+		return Conversion{}, fmt.Errorf("converting %s to %s: %v", m, to, err)
+	}
+
+	return Conversion{
+		Original:  m,
+		Converted: Money{Units: converted, Currency: to},
+		Rate:      formatRate(rate),
+	}, nil
+}
+
+// roundRat rounds to the nearest integer, with halves rounded away from zero
+func roundRat(r *big.Rat) (int64, error) {
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		half.Neg(half)
+	}
+	shifted := new(big.Rat).Add(r, half)
+	rounded := new(big.Int).Quo(shifted.Num(), shifted.Denom())
+	if !rounded.IsInt64() {
+		return 0, fmt.Errorf("amount out of range")
+	}
+	return rounded.Int64(), nil
+}
+
+// formatRate prints a rate with up to six decimals and no trailing zeroes
+func formatRate(rate *big.Rat) string {
+	s := rate.FloatString(6)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// String formats the conversion like "10.00 EUR (10.87 USD at 1.087)"
+func (c Conversion) String() string {
+	if c.Original.Currency == c.Converted.Currency {
+		return c.Converted.String()
+	}
+	return c.Original.String() + " (" + c.Converted.String() + " at " + c.Rate + ")"
+}
@@ -0,0 +1,88 @@
+package money
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestRoundRat(t *testing.T) {
+	tests := []struct {
+		num, denom int64
+		want       int64
+	}{
+		{0, 1, 0},
+		{7, 2, 4},
+		{-7, 2, -4},
+		{5, 2, 3},
+		{-5, 2, -3},
+		{1, 2, 1},
+		{-1, 2, -1},
+		{13, 10, 1},
+		{-13, 10, -1},
+		{17, 10, 2},
+		{-17, 10, -2},
+		{-1, 3, 0},
+		{-2, 3, -1},
+	}
+	for _, test := range tests {
+		got, err := roundRat(big.NewRat(test.num, test.denom))
+		if err != nil || got != test.want {
+			t.Errorf("roundRat(%d/%d) = %d, %v, want %d", test.num, test.denom, got, err, test.want)
+		}
+	}
+
+	tooLarge := new(big.Rat).Mul(big.NewRat(math.MaxInt64, 1), big.NewRat(2, 1))
+	if got, err := roundRat(tooLarge); err == nil {
+		t.Errorf("roundRat(%v) = %d, want an error", tooLarge, got)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	table := &RateTable{Base: "USD", Rates: map[string]*big.Rat{
+		"USD": big.NewRat(1, 1),
+		"EUR": big.NewRat(92, 100),
+		"JPY": big.NewRat(150, 1),
+	}}
+	tests := []struct {
+		money Money
+		to    string
+		want  Money
+		rate  string
+	}{
+		{Money{Units: 1000, Currency: "USD"}, "EUR", Money{Units: 920, Currency: "EUR"}, "0.92"},
+		{Money{Units: 1000, Currency: "EUR"}, "USD", Money{Units: 1087, Currency: "USD"}, "1.086957"},
+		{Money{Units: -1000, Currency: "EUR"}, "USD", Money{Units: -1087, Currency: "USD"}, "1.086957"},
+		{Money{Units: 1000, Currency: "USD"}, "JPY", Money{Units: 1500, Currency: "JPY"}, "150"},
+		{Money{Units: 1, Currency: "JPY"}, "USD", Money{Units: 1, Currency: "USD"}, "0.006667"},
+		{Money{Units: 1000, Currency: "EUR"}, "EUR", Money{Units: 1000, Currency: "EUR"}, "1"},
+	}
+	for _, test := range tests {
+		conversion, err := table.Convert(test.money, test.to)
+		if err != nil {
+			t.Errorf("converting %v to %s: %v", test.money, test.to, err)
+			continue
+		}
+		if conversion.Original != test.money || conversion.Converted != test.want || conversion.Rate != test.rate {
+			t.Errorf("converting %v to %s gave %+v, want %v at %s", test.money, test.to, conversion, test.want, test.rate)
+		}
+	}
+
+	if _, err := table.Convert(Money{Units: 1000, Currency: "GBP"}, "USD"); err == nil {
+		t.Error("converting from a currency without a rate succeeded")
+	}
+	if _, err := table.Convert(Money{Units: math.MaxInt64, Currency: "USD"}, "JPY"); err == nil {
+		t.Error("converting an amount out of range succeeded")
+	}
+}
+
+func TestConvertWithoutRates(t *testing.T) {
+	var table *RateTable
+	conversion, err := table.Convert(Money{Units: 1250, Currency: "EUR"}, "EUR")
+	if err != nil || conversion.Converted != (Money{Units: 1250, Currency: "EUR"}) || conversion.Rate != "1" {
+		t.Errorf("converting to the same currency without rates gave %+v, %v", conversion, err)
+	}
+	if conversion, err := table.Convert(Money{Units: 1250, Currency: "EUR"}, "USD"); err == nil {
+		t.Errorf("converting to another currency without rates gave %+v", conversion)
+	}
+}
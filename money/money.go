@@ -0,0 +1,130 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/Juules32/Auction/proto"
+)
+
+// Money is an amount in the minor units (e.g. cents) of an ISO 4217 currency
+type Money struct {
+	Units    int64  `json:"Units"`
+	Currency string `json:"Currency"`
+}
+
+// Number of decimal places for currencies that don't use the default of two
+var currencyExponents = map[string]int{
+	"BHD": 3,
+	"CLP": 0,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"VND": 0,
+}
+
+// Exponent returns the number of decimal places used by the currency
+func Exponent(currency string) int {
+	if exponent, ok := currencyExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Scale returns the number of minor units in one major unit of the currency
+func Scale(currency string) int64 {
+	scale := int64(1)
+	for i := 0; i < Exponent(currency); i++ {
+		scale *= 10
+	}
+	return scale
+}
+
+// New creates an amount from a whole number of major units (e.g. dollars)
+func New(major int64, currency string) Money {
+	return Money{Units: major * Scale(currency), Currency: currency}
+}
+
+// NormalizeCurrency upper-cases a currency code and checks that it is three letters
+func NormalizeCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if len(currency) != 3 {
+		return "", fmt.Errorf("invalid currency code %q", currency)
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("invalid currency code %q", currency)
+		}
+	}
+	return currency, nil
+}
+
+// Parse reads an amount written like "12.50 EUR"
+func Parse(s string) (Money, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Money{}, errors.New("amount must be written as '<amount> <currency>', e.g. '12.50 EUR'")
+	}
+
+	currency, err := NormalizeCurrency(fields[1])
+	if err != nil {
+		return Money{}, err
+	}
+
+	number := fields[0]
+	negative := strings.HasPrefix(number, "-")
+	number = strings.TrimPrefix(number, "-")
+
+	whole, fraction, _ := strings.Cut(number, ".")
+	exponent := Exponent(currency)
+	if whole == "" || len(fraction) > exponent {
+		return Money{}, fmt.Errorf("invalid amount %q for %s", fields[0], currency)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	// Parsing the digits as one number lets ParseInt catch overflow and stray characters
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || strings.ContainsAny(whole+fraction, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q for %s", fields[0], currency)
+	}
+	if negative {
+		units = -units
+	}
+
+	return Money{Units: units, Currency: currency}, nil
+}
+
+// String formats the amount like "12.50 EUR"
+func (m Money) String() string {
+	// The absolute value is taken as unsigned, as negating math.MinInt64 overflows
+	units := uint64(m.Units)
+	sign := ""
+	if m.Units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	exponent := Exponent(m.Currency)
+	if exponent == 0 {
+		return sign + strconv.FormatUint(units, 10) + " " + m.Currency
+	}
+
+	scale := uint64(Scale(m.Currency))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, units/scale, exponent, units%scale, m.Currency)
+}
+
+// FromProto converts a protobuf amount, treating a missing amount as zero
+func FromProto(m *pb.Money) Money {
+	return Money{Units: m.GetUnits(), Currency: m.GetCurrency()}
+}
+
+// Proto converts the amount to its protobuf representation
+func (m Money) Proto() *pb.Money {
+	return &pb.Money{Units: m.Units, Currency: m.Currency}
+}
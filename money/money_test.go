@@ -0,0 +1,91 @@
+package money
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+		// Parsing fails if set
+		invalid bool
+	}{
+		{input: "12.50 EUR", want: Money{Units: 1250, Currency: "EUR"}},
+		{input: "12.5 eur", want: Money{Units: 1250, Currency: "EUR"}},
+		{input: "12 EUR", want: Money{Units: 1200, Currency: "EUR"}},
+		{input: "0.05 EUR", want: Money{Units: 5, Currency: "EUR"}},
+		{input: "-3.25 EUR", want: Money{Units: -325, Currency: "EUR"}},
+		{input: "500 JPY", want: Money{Units: 500, Currency: "JPY"}},
+		{input: "1.234 KWD", want: Money{Units: 1234, Currency: "KWD"}},
+		{input: "9223372036854775807 JPY", want: Money{Units: math.MaxInt64, Currency: "JPY"}},
+		{input: "12.505 EUR", invalid: true},
+		{input: "12.5 JPY", invalid: true},
+		{input: "1.2345 KWD", invalid: true},
+		{input: "+12.50 EUR", invalid: true},
+		{input: "--12.50 EUR", invalid: true},
+		{input: "-+12.50 EUR", invalid: true},
+		{input: "12.-5 EUR", invalid: true},
+		{input: "12.+5 EUR", invalid: true},
+		{input: ".50 EUR", invalid: true},
+		{input: "- EUR", invalid: true},
+		{input: "12,50 EUR", invalid: true},
+		{input: "1e3 EUR", invalid: true},
+		{input: "9223372036854775808 JPY", invalid: true},
+		{input: "12.50 EURO", invalid: true},
+		{input: "12.50 E1R", invalid: true},
+		{input: "12.50", invalid: true},
+		{input: "12.50 EUR extra", invalid: true},
+		{input: "", invalid: true},
+		{input: "   ", invalid: true},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.input)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", test.input, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", test.input, got, err, test.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{Units: 1250, Currency: "EUR"}, "12.50 EUR"},
+		{Money{Units: 5, Currency: "EUR"}, "0.05 EUR"},
+		{Money{Units: -325, Currency: "EUR"}, "-3.25 EUR"},
+		{Money{Units: -5, Currency: "EUR"}, "-0.05 EUR"},
+		{Money{Units: 0, Currency: "EUR"}, "0.00 EUR"},
+		{Money{Units: 500, Currency: "JPY"}, "500 JPY"},
+		{Money{Units: -500, Currency: "JPY"}, "-500 JPY"},
+		{Money{Units: 1234, Currency: "KWD"}, "1.234 KWD"},
+		{Money{Units: math.MaxInt64, Currency: "EUR"}, "92233720368547758.07 EUR"},
+		{Money{Units: math.MinInt64, Currency: "EUR"}, "-92233720368547758.08 EUR"},
+		{Money{Units: math.MinInt64, Currency: "JPY"}, "-9223372036854775808 JPY"},
+	}
+	for _, test := range tests {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.money, got, test.want)
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	for _, input := range []string{"12.50 EUR", "-0.05 EUR", "500 JPY", "-1.234 KWD"} {
+		m, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		if got := m.String(); got != input {
+			t.Errorf("Parse(%q).String() = %q", input, got)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v4.24.3
+// source: proto/replication.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ReplicationClient is the client API for Replication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReplicationClient interface {
+	// Called by a backup on the primary to start receiving its updates
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// Applies updates following the last one the backup applied
+	AppendState(ctx context.Context, in *AppendStateRequest, opts ...grpc.CallOption) (*ReplicationResponse, error)
+	// Replaces the backup's data, for backups too far behind or of another term
+	Snapshot(ctx context.Context, in *AuctionSnapshot, opts ...grpc.CallOption) (*ReplicationResponse, error)
+	// Lets the backup know the primary is alive while there are no updates
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*ReplicationResponse, error)
+	// Asks a backup that has caught up to take over as primary, after which the primary steps down
+	TakeOver(ctx context.Context, in *TakeOverRequest, opts ...grpc.CallOption) (*ReplicationResponse, error)
+	// Asks a replica for its vote to become primary of a new term
+	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error)
+}
+
+type replicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationClient(cc grpc.ClientConnInterface) ReplicationClient {
+	return &replicationClient{cc}
+}
+
+func (c *replicationClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, "/Replication/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) AppendState(ctx context.Context, in *AppendStateRequest, opts ...grpc.CallOption) (*ReplicationResponse, error) {
+	out := new(ReplicationResponse)
+	err := c.cc.Invoke(ctx, "/Replication/AppendState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) Snapshot(ctx context.Context, in *AuctionSnapshot, opts ...grpc.CallOption) (*ReplicationResponse, error) {
+	out := new(ReplicationResponse)
+	err := c.cc.Invoke(ctx, "/Replication/Snapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*ReplicationResponse, error) {
+	out := new(ReplicationResponse)
+	err := c.cc.Invoke(ctx, "/Replication/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) TakeOver(ctx context.Context, in *TakeOverRequest, opts ...grpc.CallOption) (*ReplicationResponse, error) {
+	out := new(ReplicationResponse)
+	err := c.cc.Invoke(ctx, "/Replication/TakeOver", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error) {
+	out := new(VoteResponse)
+	err := c.cc.Invoke(ctx, "/Replication/RequestVote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReplicationServer is the server API for Replication service.
+// All implementations should embed UnimplementedReplicationServer
+// for forward compatibility
+type ReplicationServer interface {
+	// Called by a backup on the primary to start receiving its updates
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// Applies updates following the last one the backup applied
+	AppendState(context.Context, *AppendStateRequest) (*ReplicationResponse, error)
+	// Replaces the backup's data, for backups too far behind or of another term
+	Snapshot(context.Context, *AuctionSnapshot) (*ReplicationResponse, error)
+	// Lets the backup know the primary is alive while there are no updates
+	Heartbeat(context.Context, *HeartbeatRequest) (*ReplicationResponse, error)
+	// Asks a backup that has caught up to take over as primary, after which the primary steps down
+	TakeOver(context.Context, *TakeOverRequest) (*ReplicationResponse, error)
+	// Asks a replica for its vote to become primary of a new term
+	RequestVote(context.Context, *VoteRequest) (*VoteResponse, error)
+}
+
+// UnimplementedReplicationServer should be embedded to have forward compatible implementations.
+type UnimplementedReplicationServer struct {
+}
+
+func (UnimplementedReplicationServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedReplicationServer) AppendState(context.Context, *AppendStateRequest) (*ReplicationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendState not implemented")
+}
+func (UnimplementedReplicationServer) Snapshot(context.Context, *AuctionSnapshot) (*ReplicationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedReplicationServer) Heartbeat(context.Context, *HeartbeatRequest) (*ReplicationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedReplicationServer) TakeOver(context.Context, *TakeOverRequest) (*ReplicationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TakeOver not implemented")
+}
+func (UnimplementedReplicationServer) RequestVote(context.Context, *VoteRequest) (*VoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+
+// UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationServer will
+// result in compilation errors.
+type UnsafeReplicationServer interface {
+	mustEmbedUnimplementedReplicationServer()
+}
+
+func RegisterReplicationServer(s grpc.ServiceRegistrar, srv ReplicationServer) {
+	s.RegisterService(&Replication_ServiceDesc, srv)
+}
+
+func _Replication_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Replication/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_AppendState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).AppendState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Replication/AppendState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).AppendState(ctx, req.(*AppendStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_Snapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuctionSnapshot)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Snapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Replication/Snapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Snapshot(ctx, req.(*AuctionSnapshot))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Replication/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_TakeOver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TakeOverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).TakeOver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Replication/TakeOver",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).TakeOver(ctx, req.(*TakeOverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Replication/RequestVote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).RequestVote(ctx, req.(*VoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Replication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "Replication",
+	HandlerType: (*ReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _Replication_Register_Handler,
+		},
+		{
+			MethodName: "AppendState",
+			Handler:    _Replication_AppendState_Handler,
+		},
+		{
+			MethodName: "Snapshot",
+			Handler:    _Replication_Snapshot_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Replication_Heartbeat_Handler,
+		},
+		{
+			MethodName: "TakeOver",
+			Handler:    _Replication_TakeOver_Handler,
+		},
+		{
+			MethodName: "RequestVote",
+			Handler:    _Replication_RequestVote_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/replication.proto",
+}
@@ -24,6 +24,21 @@ const _ = grpc.SupportPackageIsVersion7
 type AuctionClient interface {
 	Bid(ctx context.Context, in *BidRequest, opts ...grpc.CallOption) (*BidResponse, error)
 	Result(ctx context.Context, in *ResultRequest, opts ...grpc.CallOption) (*ResultResponse, error)
+	ListBids(ctx context.Context, in *ListBidsRequest, opts ...grpc.CallOption) (*ListBidsResponse, error)
+	// Admin operations, requiring the auctioneer role
+	StartAuction(ctx context.Context, in *StartAuctionRequest, opts ...grpc.CallOption) (*StartAuctionResponse, error)
+	EndAuction(ctx context.Context, in *EndAuctionRequest, opts ...grpc.CallOption) (*EndAuctionResponse, error)
+	// Hands over to a backup, which stands for election once it has caught up, rejecting bids meanwhile
+	TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*TransferLeadershipResponse, error)
+	// Change the replicas of the cluster one at a time, each change waiting for the previous one to be
+	// applied by a quorum
+	AddReplica(ctx context.Context, in *AddReplicaRequest, opts ...grpc.CallOption) (*MembershipResponse, error)
+	RemoveReplica(ctx context.Context, in *RemoveReplicaRequest, opts ...grpc.CallOption) (*MembershipResponse, error)
+	// Moves the group's active auction to another replica group, which must not have an active auction
+	MoveAuction(ctx context.Context, in *MoveAuctionRequest, opts ...grpc.CallOption) (*MoveAuctionResponse, error)
+	// Called by the primary of the group an auction is moved from, on behalf of the auctioneer moving it.
+	// Fails with ALREADY_EXISTS if the auction has already been moved to the group, which completes a retried move.
+	ImportAuction(ctx context.Context, in *ImportAuctionRequest, opts ...grpc.CallOption) (*ImportAuctionResponse, error)
 }
 
 type auctionClient struct {
@@ -52,12 +67,99 @@ func (c *auctionClient) Result(ctx context.Context, in *ResultRequest, opts ...g
 	return out, nil
 }
 
+func (c *auctionClient) ListBids(ctx context.Context, in *ListBidsRequest, opts ...grpc.CallOption) (*ListBidsResponse, error) {
+	out := new(ListBidsResponse)
+	err := c.cc.Invoke(ctx, "/Auction/ListBids", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) StartAuction(ctx context.Context, in *StartAuctionRequest, opts ...grpc.CallOption) (*StartAuctionResponse, error) {
+	out := new(StartAuctionResponse)
+	err := c.cc.Invoke(ctx, "/Auction/StartAuction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) EndAuction(ctx context.Context, in *EndAuctionRequest, opts ...grpc.CallOption) (*EndAuctionResponse, error) {
+	out := new(EndAuctionResponse)
+	err := c.cc.Invoke(ctx, "/Auction/EndAuction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*TransferLeadershipResponse, error) {
+	out := new(TransferLeadershipResponse)
+	err := c.cc.Invoke(ctx, "/Auction/TransferLeadership", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) AddReplica(ctx context.Context, in *AddReplicaRequest, opts ...grpc.CallOption) (*MembershipResponse, error) {
+	out := new(MembershipResponse)
+	err := c.cc.Invoke(ctx, "/Auction/AddReplica", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) RemoveReplica(ctx context.Context, in *RemoveReplicaRequest, opts ...grpc.CallOption) (*MembershipResponse, error) {
+	out := new(MembershipResponse)
+	err := c.cc.Invoke(ctx, "/Auction/RemoveReplica", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) MoveAuction(ctx context.Context, in *MoveAuctionRequest, opts ...grpc.CallOption) (*MoveAuctionResponse, error) {
+	out := new(MoveAuctionResponse)
+	err := c.cc.Invoke(ctx, "/Auction/MoveAuction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionClient) ImportAuction(ctx context.Context, in *ImportAuctionRequest, opts ...grpc.CallOption) (*ImportAuctionResponse, error) {
+	out := new(ImportAuctionResponse)
+	err := c.cc.Invoke(ctx, "/Auction/ImportAuction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AuctionServer is the server API for Auction service.
 // All implementations should embed UnimplementedAuctionServer
 // for forward compatibility
 type AuctionServer interface {
 	Bid(context.Context, *BidRequest) (*BidResponse, error)
 	Result(context.Context, *ResultRequest) (*ResultResponse, error)
+	ListBids(context.Context, *ListBidsRequest) (*ListBidsResponse, error)
+	// Admin operations, requiring the auctioneer role
+	StartAuction(context.Context, *StartAuctionRequest) (*StartAuctionResponse, error)
+	EndAuction(context.Context, *EndAuctionRequest) (*EndAuctionResponse, error)
+	// Hands over to a backup, which stands for election once it has caught up, rejecting bids meanwhile
+	TransferLeadership(context.Context, *TransferLeadershipRequest) (*TransferLeadershipResponse, error)
+	// Change the replicas of the cluster one at a time, each change waiting for the previous one to be
+	// applied by a quorum
+	AddReplica(context.Context, *AddReplicaRequest) (*MembershipResponse, error)
+	RemoveReplica(context.Context, *RemoveReplicaRequest) (*MembershipResponse, error)
+	// Moves the group's active auction to another replica group, which must not have an active auction
+	MoveAuction(context.Context, *MoveAuctionRequest) (*MoveAuctionResponse, error)
+	// Called by the primary of the group an auction is moved from, on behalf of the auctioneer moving it.
+	// Fails with ALREADY_EXISTS if the auction has already been moved to the group, which completes a retried move.
+	ImportAuction(context.Context, *ImportAuctionRequest) (*ImportAuctionResponse, error)
 }
 
 // UnimplementedAuctionServer should be embedded to have forward compatible implementations.
@@ -70,6 +172,30 @@ func (UnimplementedAuctionServer) Bid(context.Context, *BidRequest) (*BidRespons
 func (UnimplementedAuctionServer) Result(context.Context, *ResultRequest) (*ResultResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Result not implemented")
 }
+func (UnimplementedAuctionServer) ListBids(context.Context, *ListBidsRequest) (*ListBidsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBids not implemented")
+}
+func (UnimplementedAuctionServer) StartAuction(context.Context, *StartAuctionRequest) (*StartAuctionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartAuction not implemented")
+}
+func (UnimplementedAuctionServer) EndAuction(context.Context, *EndAuctionRequest) (*EndAuctionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EndAuction not implemented")
+}
+func (UnimplementedAuctionServer) TransferLeadership(context.Context, *TransferLeadershipRequest) (*TransferLeadershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferLeadership not implemented")
+}
+func (UnimplementedAuctionServer) AddReplica(context.Context, *AddReplicaRequest) (*MembershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddReplica not implemented")
+}
+func (UnimplementedAuctionServer) RemoveReplica(context.Context, *RemoveReplicaRequest) (*MembershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveReplica not implemented")
+}
+func (UnimplementedAuctionServer) MoveAuction(context.Context, *MoveAuctionRequest) (*MoveAuctionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveAuction not implemented")
+}
+func (UnimplementedAuctionServer) ImportAuction(context.Context, *ImportAuctionRequest) (*ImportAuctionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportAuction not implemented")
+}
 
 // UnsafeAuctionServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to AuctionServer will
@@ -118,6 +244,150 @@ func _Auction_Result_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Auction_ListBids_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBidsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).ListBids(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/ListBids",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).ListBids(ctx, req.(*ListBidsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_StartAuction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartAuctionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).StartAuction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/StartAuction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).StartAuction(ctx, req.(*StartAuctionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_EndAuction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EndAuctionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).EndAuction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/EndAuction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).EndAuction(ctx, req.(*EndAuctionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_TransferLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferLeadershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).TransferLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/TransferLeadership",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).TransferLeadership(ctx, req.(*TransferLeadershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_AddReplica_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddReplicaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).AddReplica(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/AddReplica",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).AddReplica(ctx, req.(*AddReplicaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_RemoveReplica_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveReplicaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).RemoveReplica(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/RemoveReplica",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).RemoveReplica(ctx, req.(*RemoveReplicaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_MoveAuction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveAuctionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).MoveAuction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/MoveAuction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).MoveAuction(ctx, req.(*MoveAuctionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auction_ImportAuction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportAuctionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServer).ImportAuction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Auction/ImportAuction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServer).ImportAuction(ctx, req.(*ImportAuctionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Auction_ServiceDesc is the grpc.ServiceDesc for Auction service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -133,6 +403,38 @@ var Auction_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Result",
 			Handler:    _Auction_Result_Handler,
 		},
+		{
+			MethodName: "ListBids",
+			Handler:    _Auction_ListBids_Handler,
+		},
+		{
+			MethodName: "StartAuction",
+			Handler:    _Auction_StartAuction_Handler,
+		},
+		{
+			MethodName: "EndAuction",
+			Handler:    _Auction_EndAuction_Handler,
+		},
+		{
+			MethodName: "TransferLeadership",
+			Handler:    _Auction_TransferLeadership_Handler,
+		},
+		{
+			MethodName: "AddReplica",
+			Handler:    _Auction_AddReplica_Handler,
+		},
+		{
+			MethodName: "RemoveReplica",
+			Handler:    _Auction_RemoveReplica_Handler,
+		},
+		{
+			MethodName: "MoveAuction",
+			Handler:    _Auction_MoveAuction_Handler,
+		},
+		{
+			MethodName: "ImportAuction",
+			Handler:    _Auction_ImportAuction_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/template.proto",
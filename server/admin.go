@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+
+	"github.com/Juules32/Auction/money"
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StartAuction implements the StartAuction RPC method
+func (r *replica) StartAuction(ctx context.Context, req *pb.StartAuctionRequest) (*pb.StartAuctionResponse, error) {
+	currency := *defaultCurrency
+	if req.BaseCurrency != "" {
+		var err error
+		currency, err = money.NormalizeCurrency(req.BaseCurrency)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid currency: "+err.Error())
+		}
+	}
+
+	r.mut.Lock()
+	if !r.acceptsWrites() {
+		r.mut.Unlock()
+		return nil, notAcceptingWritesError()
+	}
+
+	r.startAuction(ctx, currency, caller(ctx))
+	response := &pb.StartAuctionResponse{
+		AuctionId:  r.auctionServer.AuctionId,
+		ItemName:   r.auctionServer.ItemName,
+		MinimumBid: r.auctionServer.MinimumBid.Proto(),
+		Term:       r.auctionServer.Term,
+		Sequence:   r.auctionServer.Sequence,
+	}
+	r.mut.Unlock()
+
+	if err := r.waitForQuorum(ctx, response.Term, response.Sequence); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// EndAuction implements the EndAuction RPC method
+func (r *replica) EndAuction(ctx context.Context, req *pb.EndAuctionRequest) (*pb.EndAuctionResponse, error) {
+	r.mut.Lock()
+	if !r.acceptsWrites() {
+		r.mut.Unlock()
+		return nil, notAcceptingWritesError()
+	}
+	if !r.auctionServer.IsActive {
+		r.mut.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, "there is no active auction")
+	}
+
+	r.endAuction(ctx, caller(ctx))
+	response := &pb.EndAuctionResponse{
+		AuctionId:          r.auctionServer.AuctionId,
+		WinningBid:         r.auctionServer.HighestBid.Converted.Proto(),
+		WinningBidOriginal: r.auctionServer.HighestBid.Original.Proto(),
+		ExchangeRate:       r.auctionServer.HighestBid.Rate,
+		Term:               r.auctionServer.Term,
+		Sequence:           r.auctionServer.Sequence,
+	}
+	r.mut.Unlock()
+
+	if err := r.waitForQuorum(ctx, response.Term, response.Sequence); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// startAuction starts a new auction in the given base currency on behalf of the operator. Must be called with mut held.
+func (r *replica) startAuction(ctx context.Context, currency string, operator string) {
+	r.auctionServer.BaseCurrency = currency
+	r.auctionServer.HighestBid = money.Conversion{Original: money.Money{Currency: currency}, Converted: money.Money{Currency: currency}, Rate: "1"}
+	r.auctionServer.MinimumBid = money.New(rand.Int63n(*maxMinimumBid), currency)
+	r.auctionServer.ItemName = templateAuctionItemNames[rand.Intn(len(templateAuctionItemNames)-1)]
+	r.auctionServer.IsActive = true
+	r.auctionServer.LastAuctionId = r.nextAuctionId()
+	r.auctionServer.AuctionId = r.auctionServer.LastAuctionId
+	r.recordAuditEvent("auction_started", map[string]string{
+		"AuctionId":    strconv.FormatInt(r.auctionServer.AuctionId, 10),
+		"ItemName":     r.auctionServer.ItemName,
+		"BaseCurrency": r.auctionServer.BaseCurrency,
+		"MinimumBid":   r.auctionServer.MinimumBid.String(),
+		"Operator":     operator,
+	})
+	r.replicateState(ctx)
+	r.writeToLogAndTerminal("Server started new auction "+strconv.FormatInt(r.auctionServer.AuctionId, 10)+" for "+r.auctionServer.ItemName+" starting at "+r.auctionServer.MinimumBid.String(), "auction", r.auctionServer.AuctionId, "operator", operator)
+}
+
+// endAuction ends the current auction on behalf of the operator. Must be called with mut held.
+func (r *replica) endAuction(ctx context.Context, operator string) {
+	r.auctionServer.IsActive = false
+	r.recordAuditEvent("auction_ended", map[string]string{
+		"AuctionId":  strconv.FormatInt(r.auctionServer.AuctionId, 10),
+		"WinningBid": r.auctionServer.HighestBid.String(),
+		"Operator":   operator,
+	})
+	r.replicateState(ctx)
+	r.writeToLogAndTerminal("Server ended auction with winning bid "+r.auctionServer.HighestBid.String(), "auction", r.auctionServer.AuctionId, "operator", operator)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Juules32/Auction/audit"
+	pb "github.com/Juules32/Auction/proto"
+)
+
+// File in the data directory holding the tamper-evident audit log
+const auditLogFile = "audit.log"
+
+// How often unsigned audit records are signed
+const auditSignInterval = 30 * time.Second
+
+// Every replica keeps its own audit log, which it only writes to while it is primary. The head of the log of every
+// replica that has been primary is replicated, so records removed from a log can be detected by the replica
+// whose log it is, however many other primaries there have been since.
+
+// AuditHead is the sequence number and hash of the last record a replica wrote to its audit log
+type AuditHead struct {
+	Sequence uint64 `json:"Sequence"`
+	Hash     string `json:"Hash"`
+}
+
+// openAuditLog opens the audit log when becoming primary, as it may have been changed while another replica was,
+// continuing the chain once the log has been verified and found to be as long as the replicas recorded
+func (r *replica) openAuditLog() {
+	r.mut.Lock()
+	head := r.auctionServer.AuditHeads[r.id]
+	r.mut.Unlock()
+
+	key, err := audit.LoadOrCreateKey(r.auditKeyPath)
+	if err != nil {
+		r.writeErrorToLogAndTerminal("Error loading audit signing key, auction events will not be audited", err)
+		return
+	}
+
+	// The log is only extended once it is known to be intact, as signing it would cover up removed records
+	report, err := audit.Verify(r.dataPath(auditLogFile), key.Public().(ed25519.PublicKey), head.Sequence)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err == nil {
+		err = checkAuditHead(report, head)
+	}
+	var opened *audit.Log
+	if err == nil {
+		opened, err = audit.Open(r.dataPath(auditLogFile), key)
+	}
+	if err != nil {
+		r.writeErrorToLogAndTerminal("Audit log failed verification, auction events will not be audited", err)
+		return
+	}
+
+	r.mut.Lock()
+	r.auditLog = opened
+	r.mut.Unlock()
+
+	// Signs records periodically so they are covered by a signature even when there are few events
+	r.startAuditSigning.Do(func() {
+		go func() {
+			ticker := time.NewTicker(auditSignInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-r.stopped:
+					return
+				case <-ticker.C:
+				}
+				r.mut.Lock()
+				if r.auditLog != nil {
+					if err := r.auditLog.Sign(); err != nil {
+						r.writeErrorToLogAndTerminal("Error signing audit log", err)
+					}
+				}
+				r.mut.Unlock()
+			}
+		}()
+	})
+}
+
+// closeAuditLog signs and closes the audit log when the replica stops being primary or stops altogether.
+// Must be called with mut held.
+func (r *replica) closeAuditLog() {
+	if r.auditLog != nil {
+		r.auditLog.Close()
+		r.auditLog = nil
+	}
+}
+
+// checkAuditHead fails if the audit log ends before the head the replicas recorded for it, or has another record
+// in its place. Records removed from the end of the log, or rewritten and chained again, still leave an intact
+// chain, but the replicas know how long it should be and which record it ended with.
+func checkAuditHead(report audit.Report, head AuditHead) error {
+	switch {
+	case report.Records < head.Sequence:
+		return fmt.Errorf("log ends at record %d but replicas recorded %d, records have been removed", report.Records, head.Sequence)
+	case report.HashAt != head.Hash:
+		return fmt.Errorf("record %d differs from the one replicas recorded, records have been rewritten", head.Sequence)
+	}
+	return nil
+}
+
+// recordAuditEvent appends an event to the audit log and stores the new head of the log
+// in the replicated state, so truncation of the log can be detected. Must be called with mut held.
+func (r *replica) recordAuditEvent(event string, fields map[string]string) {
+	if r.auditLog == nil {
+		return
+	}
+
+	if err := r.auditLog.Append(event, fields); err != nil {
+		r.writeErrorToLogAndTerminal("Error writing to audit log", err)
+		return
+	}
+	if r.auctionServer.AuditHeads == nil {
+		r.auctionServer.AuditHeads = make(map[string]AuditHead)
+	}
+	sequence, hash := r.auditLog.Head()
+	r.auctionServer.AuditHeads[r.id] = AuditHead{Sequence: sequence, Hash: hash}
+}
+
+// auditHeadsProto converts the audit log heads to their protobuf representation
+func auditHeadsProto(heads map[string]AuditHead) map[string]*pb.AuditHead {
+	converted := make(map[string]*pb.AuditHead, len(heads))
+	for id, head := range heads {
+		converted[id] = &pb.AuditHead{Sequence: head.Sequence, Hash: head.Hash}
+	}
+	return converted
+}
+
+// auditHeadsFromProto converts replicated audit log heads
+func auditHeadsFromProto(heads map[string]*pb.AuditHead) map[string]AuditHead {
+	converted := make(map[string]AuditHead, len(heads))
+	for id, head := range heads {
+		converted[id] = AuditHead{Sequence: head.Sequence, Hash: head.Hash}
+	}
+	return converted
+}
+
+// auditFields describes a ledger entry for the audit log
+func (e LedgerEntry) auditFields() map[string]string {
+	fields := map[string]string{
+		"LedgerSequence": strconv.FormatInt(e.Sequence, 10),
+		"AuctionId":      strconv.FormatInt(e.AuctionId, 10),
+		"Bidder":         e.Bidder,
+		"RequestId":      e.RequestId,
+		"Amount":         e.Amount.String(),
+		"Accepted":       strconv.FormatBool(e.Accepted),
+	}
+	if e.ConvertedAmount != nil {
+		fields["ConvertedAmount"] = e.ConvertedAmount.String()
+		fields["ExchangeRate"] = e.ExchangeRate
+	}
+	if !e.Accepted {
+		fields["Reason"] = e.Reason.String()
+	}
+	return fields
+}
+
+// verifyAuditLog checks the audit log of this replica and compares it with the head the replicas recorded for it
+func (r *replica) verifyAuditLog() {
+	publicKey, err := audit.LoadPublicKey(r.auditKeyPath + ".pub")
+	if err != nil {
+		r.writeErrorToLogAndTerminal("Audit log verification failed", err)
+		return
+	}
+
+	r.mut.Lock()
+	head := r.auctionServer.AuditHeads[r.id]
+	r.mut.Unlock()
+
+	report, err := audit.Verify(r.dataPath(auditLogFile), publicKey, head.Sequence)
+	if err != nil {
+		r.writeErrorToLogAndTerminal("Audit log verification failed", err)
+		return
+	}
+	if err := checkAuditHead(report, head); err != nil {
+		r.writeErrorToLogAndTerminal("Audit log verification failed", err)
+		return
+	}
+
+	r.writeToLogAndTerminal(fmt.Sprintf("Audit log verified: %d records, %d signatures, %d records since the last signature", report.Records, report.Signatures, report.Unsigned))
+}
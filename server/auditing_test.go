@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Juules32/Auction/audit"
+)
+
+// writeAuditLog writes an audit log of the given events signed with the key, returning its head
+func writeAuditLog(t *testing.T, path string, key ed25519.PrivateKey, events ...string) AuditHead {
+	t.Helper()
+	log, err := audit.Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := log.Append(event, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sequence, hash := log.Head()
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return AuditHead{Sequence: sequence, Hash: hash}
+}
+
+func TestCheckAuditHead(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	events := []string{"auction_started", "bid_placed", "bid_placed", "auction_ended"}
+
+	tests := []struct {
+		name string
+		// Changes the log after the replicas recorded its head
+		change func(t *testing.T, path string)
+		err    string
+	}{
+		{
+			name:   "intact",
+			change: func(t *testing.T, path string) {},
+		},
+		{
+			name: "extended",
+			change: func(t *testing.T, path string) {
+				writeAuditLog(t, path, key, "auction_started")
+			},
+		},
+		{
+			name: "truncated",
+			change: func(t *testing.T, path string) {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := strings.SplitAfter(string(data), "\n")
+				if err := os.WriteFile(path, []byte(strings.Join(lines[:2], "")), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "log ends at record 2 but replicas recorded 4, records have been removed",
+		},
+		{
+			// Closing the log added a signature record after the head, so the rewritten log is as long as the original
+			name: "rewritten and signed again",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				writeAuditLog(t, path, key, "auction_started", "bid_placed", "auction_ended", "auction_started")
+			},
+			err: "record 4 differs from the one replicas recorded, records have been rewritten",
+		},
+		{
+			name: "rewritten and extended",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				writeAuditLog(t, path, key, "auction_started", "bid_placed", "auction_ended", "auction_started", "bid_placed", "bid_placed")
+			},
+			err: "record 4 differs from the one replicas recorded, records have been rewritten",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), auditLogFile)
+			head := writeAuditLog(t, path, key, events...)
+			test.change(t, path)
+
+			report, err := audit.Verify(path, key.Public().(ed25519.PublicKey), head.Sequence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkAuditHead(report, head)
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+
+	// A replica that has never been primary has no head to check against
+	if err := checkAuditHead(audit.Report{}, AuditHead{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backupReplica is a backup registered with the primary, which sends it updates from its own goroutine
+type backupReplica struct {
+	replica *replica
+
+	id      string
+	address string
+	conn    *grpc.ClientConn
+	client  pb.ReplicationClient
+
+	// Wakes the sender when there are updates, carrying the context of the change to continue its trace
+	changed chan context.Context
+	stop    chan struct{}
+	closed  bool
+
+	// Last update the backup reported having applied, guarded by mut
+	sequence uint64
+	term     int64
+
+	// When the latest call the backup acknowledged the term of this primary with was sent, guarded by mut
+	acknowledged time.Time
+}
+
+// registerBackup starts sending updates to a backup, replacing any earlier registration of it.
+// Must be called with mut held.
+func (r *replica) registerBackup(req *pb.RegisterRequest) error {
+	conn, err := dialReplica(req.Address)
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := r.backups[req.ReplicaId]; ok {
+		existing.close()
+	}
+	backup := &backupReplica{
+		replica:  r,
+		id:       req.ReplicaId,
+		address:  req.Address,
+		conn:     conn,
+		client:   pb.NewReplicationClient(conn),
+		changed:  make(chan context.Context, 1),
+		stop:     make(chan struct{}),
+		sequence: req.Sequence,
+		term:     req.Term,
+	}
+	r.backups[req.ReplicaId] = backup
+	go backup.run()
+	backup.notify(context.Background())
+	return nil
+}
+
+// notifyBackups wakes the sender of every backup after a change. Must be called with mut held.
+func (r *replica) notifyBackups(ctx context.Context) {
+	for _, backup := range r.backups {
+		backup.notify(ctx)
+	}
+}
+
+// notify wakes the sender, unless it has already been woken for an earlier change it hasn't sent yet.
+// Only the trace of ctx is kept, as the call that made the change usually returns before the change is sent.
+func (b *backupReplica) notify(ctx context.Context) {
+	select {
+	case b.changed <- context.WithoutCancel(ctx):
+	default:
+	}
+}
+
+// close stops sending to the backup. Must be called with mut held.
+func (b *backupReplica) close() {
+	r := b.replica
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.stop)
+	b.conn.Close()
+	if r.backups[b.id] == b {
+		delete(r.backups, b.id)
+	}
+}
+
+// run sends updates to the backup as they happen, and heartbeats when there are none
+func (b *backupReplica) run() {
+	r := b.replica
+	ticker := time.NewTicker(*heartbeatInterval)
+	defer ticker.Stop()
+
+	lastReached := time.Now()
+	for {
+		ctx := context.Background()
+		select {
+		case <-b.stop:
+			return
+		case ctx = <-b.changed:
+		case <-ticker.C:
+		}
+
+		if err := b.sync(ctx); err != nil {
+			if status.Code(err) == grpccodes.Aborted {
+				return
+			}
+			replicationFailuresTotal.Inc()
+			r.logger.Load().Warn("Error replicating to backup replica", "backup", b.id, "error", err)
+
+			if time.Since(lastReached) > *backupExpiry {
+				r.writeToLogAndTerminal("Backup replica "+b.id+" is unreachable, no longer replicating to it", "backup", b.id)
+				r.mut.Lock()
+				b.close()
+				r.mut.Unlock()
+				return
+			}
+			continue
+		}
+		lastReached = time.Now()
+	}
+}
+
+// sync sends the backup the updates it is missing, a snapshot if it is too far behind, or otherwise a heartbeat
+func (b *backupReplica) sync(ctx context.Context) error {
+	r := b.replica
+	ctx, span := tracer.Start(ctx, "replicate auction data")
+	defer span.End()
+	span.SetAttributes(attribute.String("auction.backup", b.id))
+
+	r.mut.Lock()
+	updates, snapshot := r.pendingFor(b.term, b.sequence)
+	term := r.auctionServer.Term
+	heartbeat := &pb.HeartbeatRequest{PrimaryId: r.id, Term: term, Sequence: r.auctionServer.Sequence}
+	r.mut.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, *replicationTimeout)
+	defer cancel()
+
+	sent := time.Now()
+	var response *pb.ReplicationResponse
+	var err error
+	switch {
+	case snapshot != nil:
+		response, err = b.client.Snapshot(ctx, snapshot)
+	case len(updates) > 0:
+		response, err = b.client.AppendState(ctx, &pb.AppendStateRequest{Updates: updates, Term: term})
+	default:
+		response, err = b.client.Heartbeat(ctx, heartbeat)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		// The backup has seen a later term, so this primary has been replaced
+		if status.Code(err) == grpccodes.Aborted {
+			r.mut.Lock()
+			r.stepDown("backup " + b.id + " rejected term " + strconv.FormatInt(term, 10) + " as stale")
+			r.mut.Unlock()
+			return err
+		}
+
+		// The backup couldn't apply the updates, so it is sent a snapshot next
+		if status.Code(err) == grpccodes.FailedPrecondition && len(updates) > 0 {
+			r.mut.Lock()
+			b.term = 0
+			r.mut.Unlock()
+			b.notify(context.Background())
+		}
+		return err
+	}
+
+	r.mut.Lock()
+	b.sequence, b.term = response.Sequence, response.Term
+	if sent.After(b.acknowledged) {
+		b.acknowledged = sent
+	}
+	r.announceApplied()
+	behind := b.term != r.auctionServer.Term || b.sequence != r.auctionServer.Sequence
+	r.mut.Unlock()
+
+	if snapshot != nil || len(updates) > 0 {
+		replicationPushesTotal.Inc()
+		r.logger.Load().Debug("Sent auction data to backup replica", "backup", b.id, "sequence", response.Sequence, "updates", len(updates), "snapshot", snapshot != nil)
+	}
+
+	// Changes made while sending, or reported by a heartbeat, are sent right away
+	if behind {
+		b.notify(context.Background())
+	}
+	return nil
+}
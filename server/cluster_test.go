@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TestClusterFailover runs a cluster of three replicas in this process, each on its own loopback address, and checks
+// that they elect a primary, replicate its bids to the backups and elect a new primary holding them once it is gone
+func TestClusterFailover(t *testing.T) {
+	replicas := startCluster(t)
+	primary := waitForPrimary(t, replicas, 2)
+	client := dialClient(t, primary.clientAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	auction, err := client.StartAuction(ctx, &pb.StartAuctionRequest{})
+	if err != nil {
+		t.Fatalf("starting auction on primary %s: %v", primary.id, err)
+	}
+	amount := &pb.Money{Units: auction.MinimumBid.Units + 100, Currency: auction.MinimumBid.Currency}
+	bid, err := client.Bid(ctx, &pb.BidRequest{Amount: amount, RequestId: "bid-1", Bidder: "alice"})
+	if err != nil || !bid.Success {
+		t.Fatalf("bidding on primary %s: %v %v", primary.id, bid, err)
+	}
+
+	// The bid is only acknowledged once a quorum has it, and the remaining backup gets it soon after
+	for _, r := range replicas {
+		waitFor(t, "replica "+r.id+" to apply the bid", func() bool {
+			r.mut.Lock()
+			defer r.mut.Unlock()
+			return r.auctionServer.HighestBid.Original.Units == amount.Units
+		})
+	}
+
+	primary.shutdown()
+	successor := waitForPrimary(t, without(replicas, primary), 1)
+	successor.mut.Lock()
+	term := successor.auctionServer.Term
+	successor.mut.Unlock()
+	if term <= bid.Term {
+		t.Fatalf("new primary %s is of term %d, not later than term %d of %s", successor.id, term, bid.Term, primary.id)
+	}
+
+	client = dialClient(t, successor.clientAddr)
+	result, err := client.Result(ctx, &pb.ResultRequest{Consistency: pb.ReadConsistency_LINEARIZABLE})
+	if err != nil {
+		t.Fatalf("getting result from new primary %s: %v", successor.id, err)
+	}
+	if !result.IsActive || result.AuctionId != auction.AuctionId || result.HighestBidOriginal.GetUnits() != amount.Units {
+		t.Fatalf("new primary %s has result %v, want the bid of %d in auction %d", successor.id, result, amount.Units, auction.AuctionId)
+	}
+
+	// A quorum of two is left, so the new primary still accepts bids
+	amount.Units += 100
+	bid, err = client.Bid(ctx, &pb.BidRequest{Amount: amount, RequestId: "bid-2", Bidder: "bob"})
+	if err != nil || !bid.Success {
+		t.Fatalf("bidding on new primary %s: %v %v", successor.id, bid, err)
+	}
+}
+
+// TestClusterRestartedBackup checks that a backup acknowledges a bid only once it has persisted it, so when the
+// backup is killed and restarted and the primary is killed as well, the backups still elect a primary holding the bid
+func TestClusterRestartedBackup(t *testing.T) {
+	replicas := startCluster(t)
+	primary := waitForPrimary(t, replicas, 2)
+	backups := without(replicas, primary)
+	client := dialClient(t, primary.clientAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	auction, err := client.StartAuction(ctx, &pb.StartAuctionRequest{})
+	if err != nil {
+		t.Fatalf("starting auction on primary %s: %v", primary.id, err)
+	}
+
+	// With one backup stopped, the bid is acknowledged once the other has it
+	backups[1].shutdown()
+	amount := &pb.Money{Units: auction.MinimumBid.Units + 100, Currency: auction.MinimumBid.Currency}
+	bid, err := client.Bid(ctx, &pb.BidRequest{Amount: amount, RequestId: "bid-1", Bidder: "alice"})
+	if err != nil || !bid.Success {
+		t.Fatalf("bidding on primary %s: %v %v", primary.id, bid, err)
+	}
+	kill(t, backups[0])
+	primary.shutdown()
+
+	restarted := []*replica{restartReplica(t, backups[0]), restartReplica(t, backups[1])}
+	successor := waitForPrimary(t, restarted, 1)
+	client = dialClient(t, successor.clientAddr)
+	result, err := client.Result(ctx, &pb.ResultRequest{Consistency: pb.ReadConsistency_LINEARIZABLE})
+	if err != nil {
+		t.Fatalf("getting result from new primary %s: %v", successor.id, err)
+	}
+	if result.AuctionId != auction.AuctionId || result.HighestBidOriginal.GetUnits() != amount.Units {
+		t.Fatalf("new primary %s has result %v, want the bid of %d in auction %d", successor.id, result, amount.Units, auction.AuctionId)
+	}
+}
+
+// TestClusterLeadershipTransfer checks that the primary hands over to the backup asked for, which takes over the
+// auction in a later term while the former primary follows it
+func TestClusterLeadershipTransfer(t *testing.T) {
+	replicas := startCluster(t)
+	primary := waitForPrimary(t, replicas, 2)
+	target := without(replicas, primary)[0]
+	client := dialClient(t, primary.clientAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	auction, err := client.StartAuction(ctx, &pb.StartAuctionRequest{})
+	if err != nil {
+		t.Fatalf("starting auction on primary %s: %v", primary.id, err)
+	}
+	amount := &pb.Money{Units: auction.MinimumBid.Units + 100, Currency: auction.MinimumBid.Currency}
+	bid, err := client.Bid(ctx, &pb.BidRequest{Amount: amount, RequestId: "bid-1", Bidder: "alice"})
+	if err != nil || !bid.Success {
+		t.Fatalf("bidding on primary %s: %v %v", primary.id, bid, err)
+	}
+
+	transfer, err := client.TransferLeadership(ctx, &pb.TransferLeadershipRequest{ReplicaId: target.id})
+	if err != nil {
+		t.Fatalf("transferring leadership from %s to %s: %v", primary.id, target.id, err)
+	}
+	if transfer.ReplicaId != target.id {
+		t.Fatalf("leadership was transferred to %s, want %s", transfer.ReplicaId, target.id)
+	}
+
+	// The former primary registers with the new one as a backup
+	successor := waitForPrimary(t, replicas, 2)
+	if successor != target {
+		t.Fatalf("replica %s is primary after leadership was transferred to %s", successor.id, target.id)
+	}
+	successor.mut.Lock()
+	term := successor.auctionServer.Term
+	successor.mut.Unlock()
+	if term <= transfer.Term {
+		t.Fatalf("new primary %s is of term %d, not later than term %d of %s", successor.id, term, transfer.Term, primary.id)
+	}
+
+	client = dialClient(t, successor.clientAddr)
+	amount.Units += 100
+	bid, err = client.Bid(ctx, &pb.BidRequest{Amount: amount, RequestId: "bid-2", Bidder: "bob"})
+	if err != nil || !bid.Success {
+		t.Fatalf("bidding on new primary %s: %v %v", successor.id, bid, err)
+	}
+	result, err := client.Result(ctx, &pb.ResultRequest{Consistency: pb.ReadConsistency_LINEARIZABLE})
+	if err != nil {
+		t.Fatalf("getting result from new primary %s: %v", successor.id, err)
+	}
+	if result.AuctionId != auction.AuctionId || result.HighestBidOriginal.GetUnits() != amount.Units {
+		t.Fatalf("new primary %s has result %v, want the bid of %d in auction %d", successor.id, result, amount.Units, auction.AuctionId)
+	}
+}
+
+// TestClusterMembershipChange adds a fourth replica to the cluster and removes one of the original backups, and
+// checks that quorums follow the change: the added replica and the remaining backup are a quorum of the changed
+// cluster once the primary is gone as well, while they would only be half of the cluster of four
+func TestClusterMembershipChange(t *testing.T) {
+	replicas := startCluster(t)
+	primary := waitForPrimary(t, replicas, 2)
+	client := dialClient(t, primary.clientAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	auction, err := client.StartAuction(ctx, &pb.StartAuctionRequest{})
+	if err != nil {
+		t.Fatalf("starting auction on primary %s: %v", primary.id, err)
+	}
+	amount := &pb.Money{Units: auction.MinimumBid.Units + 100, Currency: auction.MinimumBid.Currency}
+	bid, err := client.Bid(ctx, &pb.BidRequest{Amount: amount, RequestId: "bid-1", Bidder: "alice"})
+	if err != nil || !bid.Success {
+		t.Fatalf("bidding on primary %s: %v %v", primary.id, bid, err)
+	}
+
+	// The new replica is started with a peer list that includes it, and registers with the primary
+	setFlag(t, peers, *peers+",r4="+freeAddr(t, loopback(3)))
+	added := startReplica(t, "r4", loopback(3))
+	waitForPrimary(t, replicas, 3)
+	response, err := client.AddReplica(ctx, &pb.AddReplicaRequest{ReplicaId: added.id})
+	if err != nil {
+		t.Fatalf("adding replica %s: %v", added.id, err)
+	}
+	if len(response.Members) != 4 {
+		t.Fatalf("cluster has replicas %v after adding %s, want four", response.Members, added.id)
+	}
+
+	removed := without(replicas, primary)[0]
+	response, err = client.RemoveReplica(ctx, &pb.RemoveReplicaRequest{ReplicaId: removed.id})
+	if err != nil {
+		t.Fatalf("removing replica %s: %v", removed.id, err)
+	}
+	if len(response.Members) != 3 || slices.ContainsFunc(response.Members, func(m *pb.ClusterMember) bool { return m.ReplicaId == removed.id }) {
+		t.Fatalf("cluster has replicas %v after removing %s", response.Members, removed.id)
+	}
+
+	removed.shutdown()
+	primary.shutdown()
+	remaining := append(without(replicas, primary, removed), added)
+	successor := waitForPrimary(t, remaining, 1)
+	client = dialClient(t, successor.clientAddr)
+	result, err := client.Result(ctx, &pb.ResultRequest{Consistency: pb.ReadConsistency_LINEARIZABLE})
+	if err != nil {
+		t.Fatalf("getting result from new primary %s: %v", successor.id, err)
+	}
+	if result.AuctionId != auction.AuctionId || result.HighestBidOriginal.GetUnits() != amount.Units {
+		t.Fatalf("new primary %s has result %v, want the bid of %d in auction %d", successor.id, result, amount.Units, auction.AuctionId)
+	}
+}
+
+// startCluster starts a cluster of three replicas, each on its own loopback address, skipping the test on systems
+// where only 127.0.0.1 is a loopback address
+func startCluster(t *testing.T) []*replica {
+	t.Helper()
+	// Every address of 127.0.0.0/8 is a loopback address on Linux, but not on every system
+	if listener, err := net.Listen("tcp", "127.0.0.2:0"); err != nil {
+		t.Skip("127.0.0.2 is not a loopback address on this system:", err)
+	} else {
+		listener.Close()
+	}
+
+	setTimings(t)
+
+	ids := []string{"r1", "r2", "r3"}
+	var peerList []string
+	for i, id := range ids {
+		peerList = append(peerList, id+"="+freeAddr(t, loopback(i)))
+	}
+	setFlag(t, peers, strings.Join(peerList, ","))
+
+	var replicas []*replica
+	for i, id := range ids {
+		replicas = append(replicas, startReplica(t, id, loopback(i)))
+	}
+	return replicas
+}
+
+// without returns the replicas other than the excluded ones
+func without(replicas []*replica, excluded ...*replica) []*replica {
+	var remaining []*replica
+	for _, r := range replicas {
+		if !slices.Contains(excluded, r) {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining
+}
+
+// loopback returns the loopback address of the ith replica
+func loopback(i int) string {
+	return "127.0.0." + strconv.Itoa(i+1)
+}
+
+// freeAddr returns an address on the host with a port that is currently free
+func freeAddr(t *testing.T, host string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", host+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// setFlag sets a flag for the duration of the test
+func setFlag[T any](t *testing.T, flag *T, value T) {
+	previous := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = previous })
+}
+
+// setTimings shortens the timings of the replicas, keeping the lease shorter than the failure timeout
+func setTimings(t *testing.T) {
+	setFlag(t, heartbeatInterval, 50*time.Millisecond)
+	setFlag(t, failureTimeout, 500*time.Millisecond)
+	setFlag(t, leaseDuration, 200*time.Millisecond)
+	setFlag(t, replicationTimeout, time.Second)
+	setFlag(t, healthListenAddr, "127.0.0.1:0")
+}
+
+// startReplica starts a replica serving on the host, with a data directory and audit signing key of its own
+func startReplica(t *testing.T, id string, host string) *replica {
+	t.Helper()
+	// The directory is removed without checking for errors, as the replica may still be writing to it
+	dir, err := os.MkdirTemp("", "auction-"+id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return runReplica(t, id, freeAddr(t, host), host+":0", filepath.Join(dir, "data"), filepath.Join(dir, "audit.key"))
+}
+
+// restartReplica starts a stopped replica again on its addresses and with its data directory
+func restartReplica(t *testing.T, r *replica) *replica {
+	t.Helper()
+	return runReplica(t, r.id, r.clientAddr, r.readAddr, r.dataDir, r.auditKeyPath)
+}
+
+// runReplica starts a replica, which is shut down at the end of the test
+func runReplica(t *testing.T, id string, clientAddr string, readAddr string, dataDir string, auditKeyPath string) *replica {
+	t.Helper()
+	r, err := newReplica(id, clientAddr, readAddr, "", dataDir, auditKeyPath)
+	if err != nil {
+		t.Fatalf("creating replica %s: %v", id, err)
+	}
+	r.baseLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := r.start(); err != nil {
+		t.Fatalf("starting replica %s: %v", id, err)
+	}
+	t.Cleanup(r.shutdown)
+	go r.run(nil)
+	return r
+}
+
+// kill stops a replica as if its process was killed, leaving its data directory as it was beforehand rather than
+// persisting the auction data as a replica shut down at the terminal does
+func kill(t *testing.T, r *replica) {
+	t.Helper()
+	path := r.dataPath(stateFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	r.shutdown()
+	if data == nil {
+		err = os.Remove(path)
+	} else {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+}
+
+// waitForPrimary waits until exactly one of the replicas is primary and has registered the given number of backups
+func waitForPrimary(t *testing.T, replicas []*replica, backups int) *replica {
+	t.Helper()
+	var primary *replica
+	waitFor(t, "a primary with "+strconv.Itoa(backups)+" backups", func() bool {
+		primary = nil
+		for _, r := range replicas {
+			r.mut.Lock()
+			if r.currentRole == "primary" && r.clientServer != nil {
+				if primary != nil {
+					r.mut.Unlock()
+					return false
+				}
+				primary = r
+			}
+			r.mut.Unlock()
+		}
+		if primary == nil {
+			return false
+		}
+		primary.mut.Lock()
+		defer primary.mut.Unlock()
+		return len(primary.backups) == backups
+	})
+	return primary
+}
+
+// waitFor waits until the condition holds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dialClient connects to the client address of a primary
+func dialClient(t *testing.T, addr string) pb.AuctionClient {
+	t.Helper()
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewAuctionClient(conn)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+
+	grpccodes "google.golang.org/grpc/codes"
+)
+
+// Clients are only answered once a quorum of the cluster, the primary included, has applied the update their
+// call made or read. A new primary needs the votes of a quorum, which only vote for candidates with data at least
+// as new as their own, so every update a client has been told about survives failover. An update the primary
+// made but couldn't get a quorum to apply may be replaced by the snapshot of the next primary, but its client
+// only got an error, and retries of bids are recognized by their request ID if the bid survived after all.
+
+// announceApplied wakes the calls waiting for a quorum to apply their update. Must be called with mut held.
+func (r *replica) announceApplied() {
+	close(r.applied)
+	r.applied = make(chan struct{})
+}
+
+// quorumApplied reports whether a quorum of the cluster, this primary included, has applied the update with the
+// given sequence number of the current term. Replicas that are still being added don't count. Must be called with mut held.
+func (r *replica) quorumApplied(sequence uint64) bool {
+	count := 1
+	for _, backup := range r.backups {
+		if r.findMember(backup.id) != nil && backup.term == r.auctionServer.Term && backup.sequence >= sequence {
+			count++
+		}
+	}
+	return count >= r.quorum()
+}
+
+// waitForQuorum waits until a quorum has applied the update with the given term and sequence number, failing if
+// this replica stops being primary of the term or the replication timeout passes first
+func (r *replica) waitForQuorum(ctx context.Context, term int64, sequence uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, *replicationTimeout)
+	defer cancel()
+
+	for {
+		r.mut.Lock()
+		if r.currentRole != "primary" || r.auctionServer.Term != term {
+			r.mut.Unlock()
+			return infrastructureError(grpccodes.Unavailable, "NOT_COMMITTED", "the primary was replaced before a quorum of replicas applied the change")
+		}
+		if r.quorumApplied(sequence) {
+			r.mut.Unlock()
+			return nil
+		}
+		wake := r.applied
+		r.mut.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return infrastructureError(grpccodes.Unavailable, "NOT_COMMITTED", "a quorum of replicas did not apply the change in time")
+		}
+	}
+}
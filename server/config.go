@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Juules32/Auction/config"
+	"github.com/Juules32/Auction/money"
+)
+
+// Settings of the replica besides those of the logging, tracing, TLS and rate limiting flags.
+// Running several clusters on one host only requires giving each its own addresses and data directory,
+// and replicas on different hosts are found through the peer list.
+var configPath = config.RegisterFlags()
+var clientListenAddr = flag.String("client-addr", "localhost:8080", "address the primary serves clients on, which replicas on the same host can share as only the primary binds it")
+var readListenAddr = flag.String("read-addr", "localhost:0", "address every replica serves results and bid history on, so reads can be spread over the backups")
+var replicationListenAddr = flag.String("replication-addr", "", "address this replica serves replication and elections on (default its address in the peer list, or "+defaultReplicationAddr+" without one)")
+var peers = flag.String("peers", "", "comma separated id=address replication addresses of every replica in the cluster, this one included (default just this replica)")
+var peersPath = flag.String("peers-file", "", "JSON file mapping the ID of every replica in the cluster to its replication address, used instead of -peers")
+var groupId = flag.String("group", "", "ID of the replica group this cluster is in the shard map")
+var shardsPath = flag.String("shards", "", "JSON file mapping the ID of every replica group to the client addresses of its replicas, which partitions auctions across the groups (e.g. shards.example.json)")
+var heartbeatInterval = flag.Duration("heartbeat-interval", time.Second, "how often the primary contacts backups when there are no updates, which should be the same for every replica")
+var failureDetector = flag.String("failure-detector", "timeout", "how backups decide the primary is dead: timeout, or phi to adapt to how regularly heartbeats arrive")
+var failureTimeout = flag.Duration("failure-timeout", 3*time.Second, "how long a backup goes without hearing from the primary before declaring it dead, which the phi detector extends when heartbeats are irregular")
+var phiThreshold = flag.Float64("phi-threshold", 8, "suspicion at which the phi detector declares the primary dead, where each step makes a wrong declaration ten times less likely")
+var leaseDuration = flag.Duration("lease-duration", 2*time.Second, "how long a quorum acknowledging the primary lets it serve results without confirming it is still primary, which must be shorter than the failure timeout")
+var backupExpiry = flag.Duration("backup-expiry", 10*time.Second, "how long the primary keeps trying to reach a backup before dropping it until it registers again")
+var dataDir = flag.String("data-dir", "", "directory holding the audit log and the persisted auction data, which no other replica may use (default data/<id>)")
+var auditKeyPath = flag.String("audit-key", "", "file holding the key signing the audit log, generated along with its public key in <file>.pub if missing, which must be outside the data directory (default keys/<id>/audit.key)")
+var replicationTimeout = flag.Duration("replication-timeout", 2*time.Second, "deadline of calls between replicas")
+var defaultCurrency = flag.String("default-currency", "USD", "base currency of auctions started without one")
+var maxMinimumBid = flag.Int64("max-minimum-bid", 100, "upper bound of the random minimum bid of new auctions, in major units of the base currency")
+var fxRatesPath = flag.String("fx-rates", "fx.json", "file with the exchange rates used to convert bids into the base currency")
+var maxProcessedBids = flag.Int("dedup-window", 1000, "number of bid request IDs remembered for deduplicating retries")
+
+// loadConfig applies the config file and environment variables to the flags and validates the settings shared by
+// every replica of the process, while those of the replica are validated by newReplica
+func loadConfig() error {
+	if err := config.Load(*configPath); err != nil {
+		return err
+	}
+
+	errs := []error{
+		config.CheckAddress("metrics-addr", *metricsListenAddr),
+		config.CheckAddress("health-addr", *healthListenAddr),
+		config.CheckPositive("replication-timeout", *replicationTimeout),
+		config.CheckPositive("heartbeat-interval", *heartbeatInterval),
+		config.CheckPositive("phi-threshold", *phiThreshold),
+		config.CheckPositive("backup-expiry", *backupExpiry),
+		config.CheckPositive("lease-duration", *leaseDuration),
+		config.CheckPositive("max-minimum-bid", *maxMinimumBid),
+		config.CheckPositive("dedup-window", *maxProcessedBids),
+		config.CheckPositive("bidder-burst", *bidderBurst),
+		config.CheckPositive("connection-burst", *connectionBurst),
+		config.CheckPositive("global-burst", *globalBurst),
+	}
+	if err := loadShards(); err != nil {
+		errs = append(errs, err)
+	}
+	if *failureTimeout <= *heartbeatInterval {
+		errs = append(errs, errors.New("-failure-timeout must be longer than -heartbeat-interval"))
+	}
+	// Backups must not stand for election while the primary can still hold a lease they have granted,
+	// and the lease must outlast the heartbeats renewing it
+	if earliest := earliestFailure(); *leaseDuration >= earliest || *leaseDuration <= *heartbeatInterval {
+		errs = append(errs, errors.New("-lease-duration must be longer than -heartbeat-interval and shorter than the "+earliest.String()+" after which the failure detector can declare the primary dead"))
+	}
+	if *failureDetector != "timeout" && *failureDetector != "phi" {
+		errs = append(errs, errors.New("-failure-detector must be timeout or phi, got "+*failureDetector))
+	}
+
+	currency, err := money.NormalizeCurrency(*defaultCurrency)
+	if err != nil {
+		errs = append(errs, errors.New("-default-currency: "+err.Error()))
+	}
+	*defaultCurrency = currency
+
+	if tlsFiles.CA != "" && tlsFiles.Cert == "" {
+		errs = append(errs, errors.New("mutual TLS between replicas requires -tls-cert and -tls-key as well as -tls-ca"))
+	}
+	// Tokens only authenticate clients, so without mutual TLS anyone reaching the replication address could
+	// replace the auction data of the backups or win their votes
+	if *authKeyPath != "" && (tlsFiles.CA == "" || tlsFiles.Cert == "") {
+		errs = append(errs, errors.New("-auth-key requires mutual TLS between replicas with -tls-ca, -tls-cert and -tls-key, or replication would be left open"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// dataPath returns the path of a file in the data directory
+func (r *replica) dataPath(name string) string {
+	return filepath.Join(r.dataDir, name)
+}
+
+// within reports whether the path is inside the directory
+func within(path string, dir string) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false, err
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A backup that has declared the primary dead stands for election in a term higher than any it has seen,
+// and becomes primary of that term once a quorum of the cluster, itself included, has voted for it.
+// Replicas vote at most once per term, and only for candidates with data at least as new as their own.
+// While a replica still hears from its primary it ignores candidates, so a replica that was cut off from
+// the cluster can't depose a working primary when it returns.
+
+// standForElection asks every other replica for its vote in a new term, returning the term if a quorum voted
+// for this replica, or 0 if it lost. Candidates wait a random part of the heartbeat interval first and give way
+// to any candidate that stood in the meantime, so replicas that declared the primary dead at the same time
+// don't keep splitting the vote, unless the primary handed over to this replica.
+func (r *replica) standForElection(transfer bool) int64 {
+	r.mut.Lock()
+	seen := r.highestTerm
+	r.mut.Unlock()
+	if !transfer {
+		time.Sleep(time.Duration(rand.Int63n(int64(*heartbeatInterval))))
+	}
+
+	// Another replica stood first, and this one may have voted for it
+	r.mut.Lock()
+	if r.highestTerm != seen {
+		r.mut.Unlock()
+		return 0
+	}
+	// The term only becomes the highest seen once voters grant it, so a candidate that is refused, e.g. because
+	// the primary is still alive, doesn't go on to reject the primary as stale
+	term := max(r.highestTerm, r.votedTerm, r.auctionServer.Term) + 1
+	r.votedTerm, r.votedFor = term, r.id
+	request := &pb.VoteRequest{
+		CandidateId:        r.id,
+		Term:               term,
+		DataTerm:           r.auctionServer.Term,
+		Sequence:           r.auctionServer.Sequence,
+		LeadershipTransfer: transfer,
+	}
+	voters := r.otherMembers()
+	needed, size := r.quorum(), len(r.auctionServer.Members)
+	r.mut.Unlock()
+
+	if err := r.persistState(); err != nil {
+		r.writeErrorToLogAndTerminal("Error persisting vote, not standing for election", err)
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *replicationTimeout)
+	defer cancel()
+
+	granted := make(chan bool, len(voters))
+	for _, voter := range voters {
+		go func(voter Member) {
+			granted <- r.requestVote(ctx, voter, request)
+		}(voter)
+	}
+
+	// Stops waiting as soon as the outcome is known
+	votes := 1
+	for remaining := len(voters); votes < needed && votes+remaining >= needed; remaining-- {
+		if <-granted {
+			votes++
+		}
+	}
+
+	r.mut.Lock()
+	won := votes >= needed && r.highestTerm <= term
+	if won {
+		r.highestTerm = term
+	}
+	r.mut.Unlock()
+	if !won {
+		electionsTotal.WithLabelValues("lost").Inc()
+		r.writeToLogAndTerminal("Lost the election of term "+strconv.FormatInt(term, 10)+" with "+strconv.Itoa(votes)+" of "+strconv.Itoa(size)+" votes", "election_term", term, "votes", votes)
+		return 0
+	}
+	electionsTotal.WithLabelValues("won").Inc()
+	r.writeToLogAndTerminal("Won the election of term "+strconv.FormatInt(term, 10)+" with "+strconv.Itoa(votes)+" of "+strconv.Itoa(size)+" votes", "election_term", term, "votes", votes)
+	return term
+}
+
+// requestVote asks a replica for its vote, reporting whether it was granted
+func (r *replica) requestVote(ctx context.Context, voter Member, request *pb.VoteRequest) bool {
+	conn, err := dialReplica(voter.Address)
+	if err != nil {
+		r.logger.Load().Warn("Error requesting vote", "voter", voter.Id, "error", err)
+		return false
+	}
+	defer conn.Close()
+
+	response, err := pb.NewReplicationClient(conn).RequestVote(ctx, request)
+	if err != nil {
+		r.logger.Load().Warn("Error requesting vote", "voter", voter.Id, "error", err)
+		return false
+	}
+
+	// A voter that has seen a later term ends the election
+	r.mut.Lock()
+	r.highestTerm = max(r.highestTerm, response.Term)
+	r.mut.Unlock()
+	return response.Granted
+}
+
+// RequestVote implements the RequestVote RPC method
+func (r *ReplicationServer) RequestVote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	r.mut.Lock()
+	if r.findMember(req.CandidateId) == nil {
+		r.mut.Unlock()
+		return nil, status.Error(codes.PermissionDenied, "replica "+req.CandidateId+" is not part of the cluster")
+	}
+
+	// Without advancing the term, so the primary isn't deposed either
+	if !req.LeadershipTransfer && r.primaryIsAlive() {
+		response := &pb.VoteResponse{Term: r.highestTerm}
+		r.mut.Unlock()
+		return response, nil
+	}
+
+	if req.Term < r.highestTerm {
+		response := &pb.VoteResponse{Term: r.highestTerm}
+		r.mut.Unlock()
+		return response, nil
+	}
+	r.checkTerm(req.Term)
+
+	upToDate := req.DataTerm > r.auctionServer.Term || (req.DataTerm == r.auctionServer.Term && req.Sequence >= r.auctionServer.Sequence)
+	granted := upToDate && (r.votedTerm != req.Term || r.votedFor == req.CandidateId)
+	if granted {
+		r.votedTerm, r.votedFor = req.Term, req.CandidateId
+
+		// The candidate is given time to win before this replica stands itself
+		r.heardFromPrimary()
+	}
+	response := &pb.VoteResponse{Term: r.highestTerm, Granted: granted}
+	r.mut.Unlock()
+
+	if !granted {
+		return response, nil
+	}
+	if err := r.persistState(); err != nil {
+		r.writeErrorToLogAndTerminal("Error persisting vote", err)
+		return nil, status.Error(codes.Unavailable, "could not persist the vote")
+	}
+	r.writeToLogAndTerminal("Voted for "+req.CandidateId+" in the election of term "+strconv.FormatInt(req.Term, 10), "candidate", req.CandidateId, "election_term", req.Term)
+	return response, nil
+}
+
+// primaryIsAlive reports whether this replica is a primary holding its lease or a backup that has heard from
+// the primary since it started following it, and whose failure detector hasn't declared the primary dead since.
+// A backup that has just started, or just lost an election, only gives the primary time to reach it before
+// standing itself, and still votes, or candidates that would win could keep losing to it and each other.
+// Must be called with mut held.
+func (r *replica) primaryIsAlive() bool {
+	if r.currentRole == "primary" {
+		return r.holdsLease()
+	}
+	return r.primaryHeard && !r.primaryDetector.Failed(time.Now())
+}
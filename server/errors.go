@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Domain reported in the details of infrastructure errors
+const errorDomain = "auction.juules32.github.com"
+
+// How long clients are asked to wait before retrying after an infrastructure error
+const infrastructureRetryDelay = time.Second
+
+// infrastructureError creates a gRPC status error for failures that are not caused by the bid itself,
+// with details describing the failure and when the client may retry
+func infrastructureError(code codes.Code, reason string, message string) error {
+	st := status.New(code, message)
+
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(infrastructureRetryDelay)},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// wrongGroupError redirects a call about an auction to the replica group hosting it, which is named in the details
+func wrongGroupError(auctionId int64, group string) error {
+	st := status.New(codes.FailedPrecondition, "auction "+strconv.FormatInt(auctionId, 10)+" is hosted by replica group "+group)
+
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "WRONG_GROUP",
+		Domain:   errorDomain,
+		Metadata: map[string]string{"auction_id": strconv.FormatInt(auctionId, 10), "group": group},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
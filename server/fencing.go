@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Every primary has its own term, higher than any term before it, which is stamped on the auction data and
+// on every call to backups and response to clients. The term fences off a primary that was only slow
+// rather than dead: backups that have seen a later term refuse its updates, and as soon as it learns of
+// the later term it steps down and stops accepting writes.
+
+// startTerm stamps the auction data with the term the new primary was elected in. Must be called with mut held.
+func (r *replica) startTerm(term int64) {
+	r.auctionServer.Term = term
+	r.deposed = make(chan struct{})
+}
+
+// checkTerm fails calls from primaries of terms older than the highest seen, and makes this replica step down
+// if it is a primary of an older term than the caller. Must be called with mut held.
+func (r *replica) checkTerm(term int64) error {
+	if term < r.highestTerm {
+		return status.Error(codes.Aborted, "term "+strconv.FormatInt(term, 10)+" is stale, term "+strconv.FormatInt(r.highestTerm, 10)+" has started")
+	}
+	if term > r.highestTerm {
+		r.highestTerm = term
+		if r.currentRole == "primary" {
+			r.stepDown("a replica of term " + strconv.FormatInt(term, 10) + " has been heard from")
+		}
+	}
+	return nil
+}
+
+// stepDown stops a deposed primary from accepting writes and replicating, so it can't diverge from the
+// new primary any further, and wakes the main goroutine to release the client address and follow
+// the new one. Anything it accepted that the new primary doesn't have is replaced by the new primary's
+// snapshot, but no client was told about it, as clients are only answered once a quorum has applied
+// their update. Must be called with mut held.
+func (r *replica) stepDown(reason string) {
+	if r.currentRole != "primary" {
+		return
+	}
+
+	r.setRole("backup")
+	for _, backup := range r.backups {
+		backup.close()
+	}
+	close(r.deposed)
+	r.announceApplied()
+	r.closeAuditLog()
+	stepDownsTotal.Inc()
+	r.writeToLogAndTerminal("Stepping down as primary replica: "+reason, "reason", reason, "highest_term", r.highestTerm)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health service names. The Auction service is only SERVING on the primary, while
+// the backup service is SERVING on backups that are in sync with the primary.
+const auctionHealthService = "Auction"
+const backupHealthService = "AuctionBackup"
+
+// serveHealth serves the standard gRPC health service on a separate address for every replica,
+// since only the primary serves on the client address. Returns the address actually listened on.
+func (r *replica) serveHealth(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r.healthCheckServer = grpc.NewServer(clientCredentials()...)
+	healthpb.RegisterHealthServer(r.healthCheckServer, r.healthServer)
+	go func() {
+		if err := r.healthCheckServer.Serve(listener); err != nil {
+			r.writeErrorToLogAndTerminal("Error serving health checks", err)
+		}
+	}()
+
+	return listener.Addr(), nil
+}
+
+// observeHealthRole updates the health of the replica when its role changes.
+// A replica becoming a backup is not ready until it is in sync.
+func (r *replica) observeHealthRole(role string) {
+	if role == "primary" {
+		r.healthServer.SetServingStatus(auctionHealthService, healthpb.HealthCheckResponse_SERVING)
+	} else {
+		r.healthServer.SetServingStatus(auctionHealthService, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	r.healthServer.SetServingStatus(backupHealthService, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// observeBackupInSync marks a backup as ready when it has received the primary's auction data,
+// and as not ready while it has lost contact with the primary
+func (r *replica) observeBackupInSync(inSync bool) {
+	if inSync {
+		r.healthServer.SetServingStatus(backupHealthService, healthpb.HealthCheckResponse_SERVING)
+	} else {
+		r.healthServer.SetServingStatus(backupHealthService, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
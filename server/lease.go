@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The primary holds a lease while a quorum of the cluster, itself included, has acknowledged its term
+// within the lease duration. Backups that acknowledged it refuse to vote until the failure timeout has passed,
+// which is longer than the lease, so while the lease is valid no other replica can have become primary.
+
+// quorum returns the number of replicas, the primary included, that make up a majority of the cluster.
+// Must be called with mut held.
+func (r *replica) quorum() int {
+	return len(r.auctionServer.Members)/2 + 1
+}
+
+// holdsLease reports whether this replica is primary and holds a valid lease. The lease is given up while
+// handing over to a backup, which may be elected as soon as it is asked to take over. Must be called with mut held.
+func (r *replica) holdsLease() bool {
+	if r.currentRole != "primary" || r.transferring {
+		return false
+	}
+
+	needed := r.quorum() - 1
+	if needed == 0 {
+		return true
+	}
+
+	// The lease runs from when the call was sent that completed the quorum, counting the most recent first.
+	// Replicas that are still being added don't count.
+	var acknowledged []time.Time
+	for _, backup := range r.backups {
+		if r.findMember(backup.id) != nil {
+			acknowledged = append(acknowledged, backup.acknowledged)
+		}
+	}
+	if len(acknowledged) < needed {
+		return false
+	}
+	sort.Slice(acknowledged, func(i, j int) bool { return acknowledged[i].After(acknowledged[j]) })
+	return time.Since(acknowledged[needed-1]) < *leaseDuration
+}
+
+// confirmLeadership sends every backup a heartbeat and waits for a quorum to acknowledge the term of this primary,
+// which also renews the lease
+func (r *replica) confirmLeadership(ctx context.Context) error {
+	r.mut.Lock()
+	if r.currentRole != "primary" {
+		r.mut.Unlock()
+		return errors.New("not the primary")
+	}
+	heartbeat := &pb.HeartbeatRequest{PrimaryId: r.id, Term: r.auctionServer.Term, Sequence: r.auctionServer.Sequence}
+	var replicas []*backupReplica
+	for _, backup := range r.backups {
+		if r.findMember(backup.id) != nil {
+			replicas = append(replicas, backup)
+		}
+	}
+	size := r.quorum()
+	r.mut.Unlock()
+
+	needed := size - 1
+	if needed == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *replicationTimeout)
+	defer cancel()
+
+	acknowledged := make(chan bool, len(replicas))
+	for _, backup := range replicas {
+		go func(backup *backupReplica) {
+			acknowledged <- backup.confirm(ctx, heartbeat) == nil
+		}(backup)
+	}
+
+	// Stops waiting as soon as the outcome is known
+	for remaining := len(replicas); needed > 0 && remaining >= needed; remaining-- {
+		if <-acknowledged {
+			needed--
+		}
+	}
+	if needed > 0 {
+		return errors.New("only " + strconv.Itoa(size-needed) + " of the " + strconv.Itoa(size) + " replicas of a quorum acknowledged the primary")
+	}
+	return nil
+}
+
+// confirm sends the backup a heartbeat outside of its regular updates, recording the acknowledgement
+func (b *backupReplica) confirm(ctx context.Context, heartbeat *pb.HeartbeatRequest) error {
+	r := b.replica
+	sent := time.Now()
+	_, err := b.client.Heartbeat(ctx, heartbeat)
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if status.Code(err) == grpccodes.Aborted {
+		r.stepDown("backup " + b.id + " rejected term " + strconv.FormatInt(heartbeat.Term, 10) + " as stale")
+	}
+	if err != nil {
+		return err
+	}
+	if sent.After(b.acknowledged) {
+		b.acknowledged = sent
+	}
+	return nil
+}
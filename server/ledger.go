@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Juules32/Auction/money"
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Page sizes used by ListBids
+const defaultBidPageSize = 20
+const maxBidPageSize = 100
+
+// LedgerEntry records a single bid attempt. Entries are only ever appended,
+// and the sequence number of an entry is its position in the ledger plus one.
+// Only bids the primary processes are recorded: those rejected before reaching it
+// (RATE_LIMITED), by a replica not accepting writes (NOT_LEADER) or for an auction
+// hosted by another replica group (WRONG_GROUP) are not, as only the primary
+// appends to the replicated ledger and the other group records the redirected bid.
+type LedgerEntry struct {
+	Sequence        int64              `json:"Sequence"`
+	AuctionId       int64              `json:"AuctionId"`
+	Bidder          string             `json:"Bidder"`
+	RequestId       string             `json:"RequestId,omitempty"`
+	Timestamp       time.Time          `json:"Timestamp"`
+	Amount          money.Money        `json:"Amount"`
+	ConvertedAmount *money.Money       `json:"ConvertedAmount,omitempty"`
+	ExchangeRate    string             `json:"ExchangeRate,omitempty"`
+	Accepted        bool               `json:"Accepted"`
+	Reason          pb.RejectionReason `json:"Reason,omitempty"`
+}
+
+// appendToLedger records the outcome of a bid attempt in the auction it was placed in
+func (r *replica) appendToLedger(req *pb.BidRequest, outcome BidOutcome) {
+	s := r.auctionServer
+	auctionId := req.AuctionId
+	if auctionId == 0 {
+		auctionId = s.AuctionId
+	}
+	s.Ledger = append(s.Ledger, LedgerEntry{
+		Sequence:        int64(len(s.Ledger)) + 1,
+		AuctionId:       auctionId,
+		Bidder:          req.Bidder,
+		RequestId:       req.RequestId,
+		Timestamp:       time.Now().UTC(),
+		Amount:          money.FromProto(req.Amount),
+		ConvertedAmount: outcome.ConvertedAmount,
+		ExchangeRate:    outcome.ExchangeRate,
+		Accepted:        outcome.Success,
+		Reason:          outcome.Reason,
+	})
+	r.recordAuditEvent("bid", s.Ledger[len(s.Ledger)-1].auditFields())
+}
+
+// ListBids implements the ListBids RPC method
+func (r *replica) ListBids(ctx context.Context, req *pb.ListBidsRequest) (*pb.ListBidsResponse, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s := r.auctionServer
+
+	if err := s.checkFreshness(req.MinTerm, req.MinSequence); err != nil {
+		return nil, err
+	}
+	if group := s.hostOf(req.AuctionId); req.AuctionId != 0 && group != "" {
+		return nil, wrongGroupError(req.AuctionId, group)
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultBidPageSize
+	}
+	if pageSize > maxBidPageSize {
+		pageSize = maxBidPageSize
+	}
+
+	// The page token is the index in the ledger to continue from
+	start := 0
+	if req.PageToken != "" {
+		var err error
+		start, err = strconv.Atoi(req.PageToken)
+		if err != nil || start < 0 || start > len(s.Ledger) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+
+	response := &pb.ListBidsResponse{Term: s.Term, Sequence: s.Sequence}
+	for i := start; i < len(s.Ledger); i++ {
+		if len(response.Bids) == pageSize {
+			response.NextPageToken = strconv.Itoa(i)
+			break
+		}
+
+		entry := s.Ledger[i]
+		if req.AuctionId != 0 && entry.AuctionId != req.AuctionId {
+			continue
+		}
+		response.Bids = append(response.Bids, entry.record())
+	}
+
+	return response, nil
+}
+
+// ledgerEntry converts a replicated ledger entry back from its protobuf representation
+func ledgerEntry(record *pb.BidRecord) LedgerEntry {
+	entry := LedgerEntry{
+		Sequence:     record.Sequence,
+		AuctionId:    record.AuctionId,
+		Bidder:       record.Bidder,
+		RequestId:    record.RequestId,
+		Timestamp:    record.Timestamp.AsTime(),
+		Amount:       money.FromProto(record.Amount),
+		ExchangeRate: record.ExchangeRate,
+		Accepted:     record.Accepted,
+		Reason:       record.Reason,
+	}
+	if record.ConvertedAmount != nil {
+		converted := money.FromProto(record.ConvertedAmount)
+		entry.ConvertedAmount = &converted
+	}
+	return entry
+}
+
+// record converts the ledger entry to its protobuf representation
+func (e LedgerEntry) record() *pb.BidRecord {
+	record := &pb.BidRecord{
+		Sequence:     e.Sequence,
+		AuctionId:    e.AuctionId,
+		Bidder:       e.Bidder,
+		RequestId:    e.RequestId,
+		Timestamp:    timestamppb.New(e.Timestamp),
+		Amount:       e.Amount.Proto(),
+		ExchangeRate: e.ExchangeRate,
+		Accepted:     e.Accepted,
+		Reason:       e.Reason,
+	}
+	if e.ConvertedAmount != nil {
+		record.ConvertedAmount = e.ConvertedAmount.Proto()
+	}
+	return record
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Juules32/Auction/config"
+	pb "github.com/Juules32/Auction/proto"
+)
+
+// Replication address used when no peers are given, making this replica a cluster of its own
+const defaultReplicationAddr = "localhost:5050"
+
+// Member is a replica of the cluster, identified by its ID and reached on its replication address
+type Member struct {
+	Id      string `json:"Id"`
+	Address string `json:"Address"`
+}
+
+// loadMembers reads the replicas of the cluster from the peers file, or otherwise from the peer list,
+// defaulting to just this replica
+func (r *replica) loadMembers() error {
+	var loaded []Member
+	switch {
+	case *peersPath != "":
+		data, err := os.ReadFile(*peersPath)
+		if err != nil {
+			return errors.New("-peers-file: " + err.Error())
+		}
+		var addresses map[string]string
+		if err := json.Unmarshal(data, &addresses); err != nil {
+			return errors.New("-peers-file: " + err.Error())
+		}
+		for id, address := range addresses {
+			loaded = append(loaded, Member{Id: id, Address: address})
+		}
+	case *peers != "":
+		for _, entry := range config.SplitList(*peers) {
+			id, address, ok := strings.Cut(entry, "=")
+			if !ok {
+				return errors.New("-peers: " + entry + " is not of the form id=address")
+			}
+			loaded = append(loaded, Member{Id: strings.TrimSpace(id), Address: strings.TrimSpace(address)})
+		}
+	default:
+		address := r.replicationListenAddr
+		if address == "" {
+			address = defaultReplicationAddr
+		}
+		loaded = []Member{{Id: r.id, Address: address}}
+	}
+	sortMembers(loaded)
+
+	var errs []error
+	for i, m := range loaded {
+		if m.Id == "" {
+			errs = append(errs, errors.New("-peers: missing replica ID for "+m.Address))
+		}
+		if i > 0 && loaded[i-1].Id == m.Id {
+			errs = append(errs, errors.New("-peers: replica "+m.Id+" is listed twice"))
+		}
+		if m.Id == r.id {
+			r.advertisedAddr = m.Address
+		}
+		errs = append(errs, config.CheckAddress("peers", m.Address))
+	}
+	r.configuredMembers = loaded
+	if r.advertisedAddr == "" {
+		errs = append(errs, errors.New("-id "+r.id+" is not one of the replicas in the peer list"))
+	}
+	return errors.Join(errs...)
+}
+
+// sortMembers orders replicas by ID
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool { return members[i].Id < members[j].Id })
+}
+
+// findMember returns the replica of the cluster with the given ID, or nil if it isn't part of the cluster.
+// Must be called with mut held.
+func (r *replica) findMember(id string) *Member {
+	for i := range r.auctionServer.Members {
+		if r.auctionServer.Members[i].Id == id {
+			return &r.auctionServer.Members[i]
+		}
+	}
+	return nil
+}
+
+// isMember reports whether this replica is part of the cluster. Must be called with mut held.
+func (r *replica) isMember() bool {
+	return r.findMember(r.id) != nil
+}
+
+// otherMembers returns every replica of the cluster besides this one. Must be called with mut held.
+func (r *replica) otherMembers() []Member {
+	var others []Member
+	for _, m := range r.auctionServer.Members {
+		if m.Id != r.id {
+			others = append(others, m)
+		}
+	}
+	return others
+}
+
+// replicationAddr returns the address to serve replication on, which is the address other replicas
+// know this one by unless -replication-addr is set, e.g. to listen on every interface
+func (r *replica) replicationAddr() string {
+	if r.replicationListenAddr != "" {
+		return r.replicationListenAddr
+	}
+	return r.advertisedAddr
+}
+
+// membersProto converts the replicas of the cluster to their protobuf representation
+func membersProto(members []Member) []*pb.ClusterMember {
+	var converted []*pb.ClusterMember
+	for _, m := range members {
+		converted = append(converted, &pb.ClusterMember{ReplicaId: m.Id, Address: m.Address})
+	}
+	return converted
+}
+
+// membersFromProto converts replicas of the cluster from their protobuf representation
+func membersFromProto(members []*pb.ClusterMember) []Member {
+	var converted []Member
+	for _, m := range members {
+		converted = append(converted, Member{Id: m.ReplicaId, Address: m.Address})
+	}
+	return converted
+}
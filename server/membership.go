@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replicas are added to and removed from the cluster one at a time. Each change is an update of the auction data,
+// which every replica counts quorums by as soon as it has applied it, and the next change can only be made once
+// a quorum of the changed cluster has applied it. Majorities of two clusters that differ by one replica always
+// overlap, so there is never more than one primary while a change is being replicated.
+
+// AddReplica implements the AddReplica RPC method
+func (r *replica) AddReplica(ctx context.Context, req *pb.AddReplicaRequest) (*pb.MembershipResponse, error) {
+	return r.addReplica(ctx, req.ReplicaId, caller(ctx))
+}
+
+// RemoveReplica implements the RemoveReplica RPC method
+func (r *replica) RemoveReplica(ctx context.Context, req *pb.RemoveReplicaRequest) (*pb.MembershipResponse, error) {
+	return r.removeReplica(ctx, req.ReplicaId, caller(ctx))
+}
+
+// addReplica adds a replica to the cluster on behalf of the operator. The replica must have registered with
+// the primary, and is only added once it has caught up, so the cluster never counts on a replica that would
+// hold up a quorum while it fetches the auction data.
+func (r *replica) addReplica(ctx context.Context, id string, operator string) (*pb.MembershipResponse, error) {
+	r.mut.Lock()
+	if err := r.checkMembershipChange(); err != nil {
+		r.mut.Unlock()
+		return nil, err
+	}
+	if r.findMember(id) != nil {
+		r.mut.Unlock()
+		return nil, status.Error(codes.AlreadyExists, "replica "+id+" is already part of the cluster")
+	}
+	replica := r.backups[id]
+	if replica == nil {
+		r.mut.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, "replica "+id+" has not registered with the primary, start it with the peer list of the cluster first")
+	}
+	sequence := r.auctionServer.Sequence
+	r.mut.Unlock()
+
+	// Bids are still accepted meanwhile, so the replica only has to catch up with the data as of the request
+	deadline := time.Now().Add(*replicationTimeout)
+	for {
+		r.mut.Lock()
+		caughtUp := replica.term == r.auctionServer.Term && replica.sequence >= sequence
+		closed := replica.closed
+		r.mut.Unlock()
+
+		if caughtUp {
+			break
+		}
+		if closed {
+			return nil, status.Error(codes.Unavailable, "replica "+id+" is no longer registered")
+		}
+		if time.Now().After(deadline) {
+			return nil, status.Error(codes.DeadlineExceeded, "replica "+id+" did not catch up in time")
+		}
+		replica.notify(ctx)
+		time.Sleep(transferPollInterval)
+	}
+
+	return r.changeMembership(ctx, "replica_added", id, operator, func(members []Member) ([]Member, error) {
+		if r.findMember(id) != nil {
+			return nil, status.Error(codes.AlreadyExists, "replica "+id+" is already part of the cluster")
+		}
+		return append(members, Member{Id: id, Address: replica.address}), nil
+	})
+}
+
+// removeReplica removes a replica from the cluster on behalf of the operator. The primary can't remove itself,
+// but can hand over to a backup that then removes it. A removed replica keeps receiving updates until it is
+// stopped, so it can be added again, but no longer counts towards quorums or stands for election.
+func (r *replica) removeReplica(ctx context.Context, id string, operator string) (*pb.MembershipResponse, error) {
+	if id == r.id {
+		return nil, status.Error(codes.FailedPrecondition, "the primary can't remove itself, transfer leadership first")
+	}
+
+	return r.changeMembership(ctx, "replica_removed", id, operator, func(members []Member) ([]Member, error) {
+		i := slices.IndexFunc(members, func(m Member) bool { return m.Id == id })
+		if i < 0 {
+			return nil, status.Error(codes.NotFound, "replica "+id+" is not part of the cluster")
+		}
+		return slices.Delete(members, i, i+1), nil
+	})
+}
+
+// changeMembership replaces the replicas of the cluster with the result of change, replicates the change and
+// waits for a quorum of the changed cluster to apply it, after which the next change can be made
+func (r *replica) changeMembership(ctx context.Context, event string, id string, operator string, change func([]Member) ([]Member, error)) (*pb.MembershipResponse, error) {
+	r.mut.Lock()
+	// Another change may have been made while the replica to add was catching up
+	if err := r.checkMembershipChange(); err != nil {
+		r.mut.Unlock()
+		return nil, err
+	}
+	members, err := change(slices.Clone(r.auctionServer.Members))
+	if err != nil {
+		r.mut.Unlock()
+		return nil, err
+	}
+	sortMembers(members)
+	r.auctionServer.Members = members
+
+	r.recordAuditEvent(event, map[string]string{
+		"Replica":  id,
+		"Members":  memberIds(members),
+		"Operator": operator,
+	})
+	r.replicateState(ctx)
+	r.membershipSequence = r.auctionServer.Sequence
+	response := &pb.MembershipResponse{Members: membersProto(members), Term: r.auctionServer.Term, Sequence: r.auctionServer.Sequence}
+	r.mut.Unlock()
+
+	membershipChangesTotal.WithLabelValues(event).Inc()
+	r.writeToLogAndTerminal("Changed the replicas of the cluster to "+memberIds(members), "event", event, "replica", id, "operator", operator)
+
+	deadline := time.Now().Add(*replicationTimeout)
+	for {
+		r.mut.Lock()
+		committed := r.membershipCommitted()
+		r.mut.Unlock()
+
+		if committed {
+			return response, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, status.Error(codes.DeadlineExceeded, "the change was made, but a quorum has not applied it yet, so the next change has to wait until it has")
+		}
+		time.Sleep(transferPollInterval)
+	}
+}
+
+// checkMembershipChange fails if this replica can't change the replicas of the cluster yet. Must be called with mut held.
+func (r *replica) checkMembershipChange() error {
+	if !r.acceptsWrites() {
+		return notAcceptingWritesError()
+	}
+	if !r.membershipCommitted() {
+		return status.Error(codes.FailedPrecondition, "a quorum has not yet applied the previous change, or the data this primary started its term with")
+	}
+	return nil
+}
+
+// membershipCommitted reports whether a quorum of the cluster, this primary included, has applied the update that
+// last changed its replicas. Must be called with mut held.
+func (r *replica) membershipCommitted() bool {
+	return r.quorumApplied(r.membershipSequence)
+}
+
+// memberIds lists the IDs of the replicas for logging
+func memberIds(members []Member) string {
+	var ids []string
+	for _, m := range members {
+		ids = append(ids, m.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// describeMembers lists the replicas of the cluster and their addresses. Must be called with mut held.
+func (r *replica) describeMembers() string {
+	var described []string
+	for _, m := range r.auctionServer.Members {
+		description := m.Id + "=" + m.Address
+		if m.Id == r.id {
+			description += " (this replica)"
+		}
+		described = append(described, description)
+	}
+	return "Replicas of the cluster in term " + strconv.FormatInt(r.auctionServer.Term, 10) + ": " + strings.Join(described, ", ")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	bidsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auction_bids_total",
+		Help: "Bids processed by the primary, by result and rejection reason.",
+	}, []string{"result", "reason"})
+
+	resultCallsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_result_calls_total",
+		Help: "Calls to the Result RPC.",
+	})
+
+	leaseExpiredReadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_lease_expired_reads_total",
+		Help: "Calls to the Result RPC rejected because the primary did not hold its lease.",
+	})
+
+	replicaBehindReadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_replica_behind_reads_total",
+		Help: "Reads rejected because the replica's data was older than the client asked for.",
+	})
+
+	replicationPushesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_replication_pushes_total",
+		Help: "Auction data successfully sent to backup replicas.",
+	})
+
+	replicationFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_replication_failures_total",
+		Help: "Failed attempts to send auction data to backup replicas.",
+	})
+
+	leaderChangesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_leader_changes_total",
+		Help: "Times this replica has become primary.",
+	})
+
+	electionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auction_elections_total",
+		Help: "Elections this replica has stood in, by result.",
+	}, []string{"result"})
+
+	stepDownsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_step_downs_total",
+		Help: "Times this replica has stepped down as primary after learning of a later term.",
+	})
+
+	leadershipTransfersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auction_leadership_transfers_total",
+		Help: "Attempts to hand over leadership to a backup, by result.",
+	}, []string{"result"})
+
+	membershipChangesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auction_membership_changes_total",
+		Help: "Replicas added to and removed from the cluster, by event.",
+	}, []string{"event"})
+
+	auctionMovesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auction_moves_total",
+		Help: "Auctions moved between replica groups, by whether they were moved out of or into this group.",
+	}, []string{"direction"})
+
+	roleGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auction_role",
+		Help: "Current role of the replica, 1 for the active role and 0 otherwise.",
+	}, []string{"role"})
+
+	termGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "auction_term",
+		Help: "Term of the auction data held by the replica.",
+	})
+
+	replicationLagSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "auction_replication_lag_seconds",
+		Help: "Time between the primary updating the auction data and this backup receiving it.",
+	})
+
+	primarySuspicion = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "auction_primary_suspicion",
+		Help: "Suspicion of the primary by the failure detector of this backup, as a fraction of the timeout or as phi.",
+	})
+
+	primaryFailuresDetectedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auction_primary_failures_detected_total",
+		Help: "Times the failure detector of this backup has declared the primary dead.",
+	})
+
+	rpcDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auction_rpc_duration_seconds",
+		Help:    "Latency of gRPC calls served by the replica, by method and status code.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"method", "code"})
+
+	rateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auction_rate_limited_total",
+		Help: "Calls rejected by a rate limit, by the limit exceeded and method.",
+	}, []string{"limit", "method"})
+)
+
+// Roles a replica can have
+var roles = []string{"primary", "backup"}
+
+// observeRole marks the given role as the active one
+func observeRole(role string, term int64) {
+	for _, r := range roles {
+		value := 0.0
+		if r == role {
+			value = 1
+		}
+		roleGauge.WithLabelValues(r).Set(value)
+	}
+	termGauge.Set(float64(term))
+}
+
+// observeBid counts a processed bid
+func observeBid(outcome BidOutcome) {
+	if outcome.Success {
+		bidsTotal.WithLabelValues("accepted", "").Inc()
+	} else {
+		bidsTotal.WithLabelValues("rejected", outcome.Reason.String()).Inc()
+	}
+}
+
+// metricsInterceptor records the latency of every unary gRPC call
+func metricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	rpcDurationSeconds.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// Reason label values are fixed so rejected bids of every reason show up from the start
+func init() {
+	for reason := range pb.RejectionReason_name {
+		if reason != int32(pb.RejectionReason_REJECTION_REASON_UNSPECIFIED) {
+			bidsTotal.WithLabelValues("rejected", pb.RejectionReason(reason).String())
+		}
+	}
+	bidsTotal.WithLabelValues("accepted", "")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// File in the data directory the auction data is persisted to, so a restarted replica can rejoin
+// and only fetch the updates it missed
+const stateFile = "auction.state"
+
+// File in the data directory naming the replica it belongs to
+const ownerFile = "replica.id"
+
+// claimDataDir makes the data directory belong to this replica, failing if it belongs to another. Replicas sharing
+// one would overwrite each other's auction data and votes, so one of them could vote twice in a term.
+func (r *replica) claimDataDir() error {
+	path := r.dataPath(ownerFile)
+	owner, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.WriteFile(path, []byte(r.id+"\n"), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if id := strings.TrimSpace(string(owner)); id != r.id {
+		return errors.New(r.dataDir + " belongs to replica " + id + ", and every replica needs a data directory of its own")
+	}
+	return nil
+}
+
+// restoreState loads the auction data persisted by an earlier run, reporting whether there was any
+func (r *replica) restoreState() (bool, error) {
+	data, err := os.ReadFile(r.dataPath(stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	state := &pb.PersistedState{}
+	if err := proto.Unmarshal(data, state); err != nil {
+		return false, err
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.auctionServer = auctionServerFromSnapshot(state.Snapshot)
+	r.highestTerm = state.HighestTerm
+	r.votedTerm, r.votedFor = state.VotedTerm, state.VotedFor
+	r.persistedTerm, r.persistedSequence, r.persistedHighestTerm, r.persistedVotedTerm = r.auctionServer.Term, r.auctionServer.Sequence, r.highestTerm, r.votedTerm
+	return true, nil
+}
+
+// persistPeriodically persists the auction data every heartbeat interval until the replica is shut down. Backups
+// persist updates before acknowledging them, so those a quorum has applied are on disk, and the primary's own
+// updates made since are fetched from the next primary after a restart.
+func (r *replica) persistPeriodically() {
+	ticker := time.NewTicker(*heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case <-ticker.C:
+		}
+		if err := r.persistState(); err != nil {
+			r.writeErrorToLogAndTerminal("Error persisting auction data", err)
+		}
+	}
+}
+
+// persistState writes the auction data and the vote of this replica to the data directory if they have changed
+// since they were last written. The file is replaced in one step, so a crash while writing leaves the previous version.
+func (r *replica) persistState() error {
+	r.persistMut.Lock()
+	defer r.persistMut.Unlock()
+
+	r.mut.Lock()
+	term, sequence := r.auctionServer.Term, r.auctionServer.Sequence
+	if term == r.persistedTerm && sequence == r.persistedSequence && r.highestTerm == r.persistedHighestTerm && r.votedTerm == r.persistedVotedTerm {
+		r.mut.Unlock()
+		return nil
+	}
+	state := &pb.PersistedState{Snapshot: r.auctionServer.snapshot(), HighestTerm: r.highestTerm, VotedTerm: r.votedTerm, VotedFor: r.votedFor}
+	r.mut.Unlock()
+
+	data, err := proto.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := r.dataPath(stateFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	r.mut.Lock()
+	r.persistedTerm, r.persistedSequence, r.persistedHighestTerm, r.persistedVotedTerm = term, sequence, state.HighestTerm, state.VotedTerm
+	r.mut.Unlock()
+	r.logger.Load().Debug("Persisted auction data", "sequence", sequence, "data_term", term)
+	return nil
+}
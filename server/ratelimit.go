@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/Juules32/Auction/auth"
+	pb "github.com/Juules32/Auction/proto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+)
+
+// Limiters that haven't been used for this long are forgotten
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+// Token bucket limits, with a rate of 0 disabling the limit
+var bidderRate = flag.Float64("bidder-rate", 5, "bids per second allowed for each bidder, 0 for no limit")
+var bidderBurst = flag.Int("bidder-burst", 10, "bids a bidder may place at once before being limited")
+var connectionRate = flag.Float64("connection-rate", 20, "calls per second allowed on each client connection, 0 for no limit")
+var connectionBurst = flag.Int("connection-burst", 40, "calls a connection may make at once before being limited")
+var globalRate = flag.Float64("global-rate", 500, "calls per second allowed across all clients, 0 for no limit")
+var globalBurst = flag.Int("global-burst", 1000, "calls all clients may make at once before being limited")
+
+// rateLimiters holds a token bucket for every key, such as a bidder or connection
+type rateLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mut      sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+type rateLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// newRateLimiters creates token buckets filling at perSecond, or no limit if it is 0
+func newRateLimiters(perSecond float64, burst int) *rateLimiters {
+	limit := rate.Limit(perSecond)
+	if perSecond <= 0 {
+		limit = rate.Inf
+	}
+	return &rateLimiters{limit: limit, burst: burst, limiters: make(map[string]*rateLimiter)}
+}
+
+// allow takes a token from the bucket of the key, reporting whether there was one
+func (l *rateLimiters) allow(key string) bool {
+	if l.limit == rate.Inf {
+		return true
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	entry, ok := l.limiters[key]
+	if !ok {
+		entry = &rateLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastUsed = time.Now()
+	return entry.limiter.Allow()
+}
+
+// forgetIdle removes the buckets of keys that haven't been seen in a while, which are full again anyway
+func (l *rateLimiters) forgetIdle() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	for key, entry := range l.limiters {
+		if time.Since(entry.lastUsed) > rateLimiterIdleTimeout {
+			delete(l.limiters, key)
+		}
+	}
+}
+
+// forgetIdleLimiters regularly forgets the buckets of idle bidders and connections until the replica is shut down
+func (r *replica) forgetIdleLimiters() {
+	ticker := time.NewTicker(rateLimiterIdleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case <-ticker.C:
+		}
+		r.bidderLimiters.forgetIdle()
+		r.connectionLimiters.forgetIdle()
+	}
+}
+
+// rateLimitInterceptor rejects calls exceeding the global, per connection or per bidder rate limits
+// before they take the auction lock. Bids are rejected with the RATE_LIMITED reason, and other calls
+// with a RESOURCE_EXHAUSTED error.
+func (r *replica) rateLimitInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	limit := ""
+	if !r.globalLimiters.allow("") {
+		limit = "global"
+	} else if p, ok := peer.FromContext(ctx); ok && !r.connectionLimiters.allow(p.Addr.String()) {
+		limit = "connection"
+	} else if bidReq, ok := req.(*pb.BidRequest); ok && !r.bidderLimiters.allow(bidderIdentity(ctx, bidReq)) {
+		limit = "bidder"
+	}
+	if limit == "" {
+		return handler(ctx, req)
+	}
+
+	rateLimitedTotal.WithLabelValues(limit, info.FullMethod).Inc()
+	r.logger.Load().Warn("Call rejected by rate limit", "limit", limit, "method", info.FullMethod, "caller", caller(ctx))
+
+	if _, ok := req.(*pb.BidRequest); ok {
+		outcome := rejectBid(pb.RejectionReason_RATE_LIMITED, "Too many requests, exceeded the "+limit+" rate limit")
+		observeBid(outcome)
+		return outcome.response(0, 0), nil
+	}
+	return nil, infrastructureError(codes.ResourceExhausted, "RATE_LIMITED", "too many requests, exceeded the "+limit+" rate limit")
+}
+
+// bidderIdentity is the authenticated bidder, or the bidder named in the request if authentication is disabled
+func bidderIdentity(ctx context.Context, req *pb.BidRequest) string {
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		return claims.Subject
+	}
+	return req.Bidder
+}
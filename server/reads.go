@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReadOnlyServer serves the read side of the Auction service on the read address of every replica,
+// so reads can be spread over the backups. Results other than stale ones still require the primary.
+type ReadOnlyServer struct {
+	*replica
+}
+
+// serveReads starts serving reads on the read address, returning the address it listens on
+func (r *replica) serveReads(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r.readServer = r.newClientServer()
+	pb.RegisterAuctionServer(r.readServer, &ReadOnlyServer{r})
+	go func() {
+		if err := r.readServer.Serve(listener); err != nil {
+			r.writeErrorToLogAndTerminal("Error serving reads", err)
+		}
+	}()
+	return listener.Addr(), nil
+}
+
+// Result implements the Result RPC method
+func (r *ReadOnlyServer) Result(ctx context.Context, req *pb.ResultRequest) (*pb.ResultResponse, error) {
+	return r.replica.Result(ctx, req)
+}
+
+// ListBids implements the ListBids RPC method
+func (r *ReadOnlyServer) ListBids(ctx context.Context, req *pb.ListBidsRequest) (*pb.ListBidsResponse, error) {
+	return r.replica.ListBids(ctx, req)
+}
+
+// Bid implements the Bid RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) Bid(ctx context.Context, req *pb.BidRequest) (*pb.BidResponse, error) {
+	return nil, readOnlyError()
+}
+
+// StartAuction implements the StartAuction RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) StartAuction(ctx context.Context, req *pb.StartAuctionRequest) (*pb.StartAuctionResponse, error) {
+	return nil, readOnlyError()
+}
+
+// EndAuction implements the EndAuction RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) EndAuction(ctx context.Context, req *pb.EndAuctionRequest) (*pb.EndAuctionResponse, error) {
+	return nil, readOnlyError()
+}
+
+// TransferLeadership implements the TransferLeadership RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) TransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.TransferLeadershipResponse, error) {
+	return nil, readOnlyError()
+}
+
+// AddReplica implements the AddReplica RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) AddReplica(ctx context.Context, req *pb.AddReplicaRequest) (*pb.MembershipResponse, error) {
+	return nil, readOnlyError()
+}
+
+// RemoveReplica implements the RemoveReplica RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) RemoveReplica(ctx context.Context, req *pb.RemoveReplicaRequest) (*pb.MembershipResponse, error) {
+	return nil, readOnlyError()
+}
+
+// MoveAuction implements the MoveAuction RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) MoveAuction(ctx context.Context, req *pb.MoveAuctionRequest) (*pb.MoveAuctionResponse, error) {
+	return nil, readOnlyError()
+}
+
+// ImportAuction implements the ImportAuction RPC method, which is only served on the client address of the primary
+func (r *ReadOnlyServer) ImportAuction(ctx context.Context, req *pb.ImportAuctionRequest) (*pb.ImportAuctionResponse, error) {
+	return nil, readOnlyError()
+}
+
+// readOnlyError is returned for writes sent to a read address
+func readOnlyError() error {
+	return status.Error(codes.FailedPrecondition, "the read address only serves results and bid history, writes go to the primary's client address")
+}
+
+// checkFreshness fails reads of data older than the given term and update, which the client has already seen.
+// Data of a later term is newer whatever its sequence number. Must be called with mut held.
+func (s *AuctionServer) checkFreshness(minTerm int64, minSequence uint64) error {
+	if s.Term > minTerm || (s.Term == minTerm && s.Sequence >= minSequence) {
+		return nil
+	}
+	replicaBehindReadsTotal.Inc()
+	return infrastructureError(codes.Unavailable, "REPLICA_BEHIND", fmt.Sprintf("the replica has data up to update %d of term %d, older than update %d of term %d", s.Sequence, s.Term, minSequence, minTerm))
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Juules32/Auction/audit"
+	"github.com/Juules32/Auction/config"
+	"github.com/Juules32/Auction/failure"
+	"github.com/Juules32/Auction/money"
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// replica is a replica of the auction cluster: its settings, its copy of the auction data and what it knows about
+// the rest of the cluster. The process runs a single replica, while tests run several in one process, so settings
+// that tell replicas apart are kept here and the rest are read from the flags.
+type replica struct {
+	// ID of the replica, which must stay the same across restarts
+	id string
+
+	// Addresses the primary serves clients on, every replica serves reads on, and this replica serves replication
+	// on if it differs from its address in the peer list
+	clientAddr            string
+	readAddr              string
+	replicationListenAddr string
+
+	// Directory holding the audit log and the persisted auction data, and the file holding the key signing the
+	// audit log, which is kept elsewhere so the log can't be rewritten and signed again from the data directory
+	dataDir      string
+	auditKeyPath string
+
+	// Replicas of the cluster according to the peer list, which are only used until the auction data has its own
+	configuredMembers []Member
+
+	// Address other replicas reach this one on according to the peer list
+	advertisedAddr string
+
+	// Auction data of the replica, and the lock guarding it along with everything else said to be guarded by mut
+	auctionServer *AuctionServer
+	mut           sync.Mutex
+
+	// Logger of the replica, replaced under mut when the role or term changes but used without it, and the logger
+	// it was derived from before the role and term were attached
+	logger     atomic.Pointer[slog.Logger]
+	baseLogger *slog.Logger
+
+	// Either "primary" or "backup"
+	currentRole string
+
+	// Health of the replica, served on its own address and next to the Auction service on the primary
+	healthServer *health.Server
+
+	// Servers of the Replication service, of reads and of health checks
+	replicationServer *grpc.Server
+	readServer        *grpc.Server
+	healthCheckServer *grpc.Server
+
+	// Server clients are served by while this replica is primary, guarded by mut
+	clientServer *grpc.Server
+
+	// Closed when the replica is shut down
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// Rate limits of the client address
+	bidderLimiters, connectionLimiters, globalLimiters *rateLimiters
+
+	// Exchange rates, reloaded whenever the rate file changes
+	fxRates        *money.RateTable
+	fxRatesModTime time.Time
+
+	// Highest term this replica has seen, its own included, guarded by mut
+	highestTerm int64
+
+	// Closed when the primary steps down, guarded by mut
+	deposed chan struct{}
+
+	// Term this replica last voted in and the candidate it voted for, persisted before the vote is cast
+	// and guarded by mut
+	votedTerm int64
+	votedFor  string
+
+	// Whether this backup has been in sync with a primary since it started, guarded by mut. A backup that is still
+	// catching up after joining or restarting leaves elections to those that have, while any primary is around.
+	caughtUp bool
+
+	// Failure detector of the primary this backup follows, fed by every contact from it, and whether it has been
+	// fed since it was created, both guarded by mut
+	primaryDetector failure.Detector
+	primaryHeard    bool
+
+	// Registered backups by replica ID, guarded by mut
+	backups map[string]*backupReplica
+
+	// Updates recorded by the primary, oldest first, along with what has been included in them so far
+	recentUpdates          []*pb.StateUpdate
+	replicatedLedgerLength int
+	unreplicatedBids       []string
+
+	// Closed and replaced whenever a backup reports the updates it has applied, guarded by mut
+	applied chan struct{}
+
+	// Sequence number of the update that last changed the replicas of the cluster, or that this primary started its
+	// term with, which a quorum must have applied before the next change. Guarded by mut.
+	membershipSequence uint64
+
+	// Set while the primary hands over to a backup, during which writes are rejected so the backup can catch up.
+	// Guarded by mut.
+	transferring bool
+
+	// Handovers this backup has accepted, which its follower loop picks up
+	takeovers chan *pb.TakeOverRequest
+
+	// Audit log of this replica while it is primary, nil if it couldn't be opened. Guarded by mut.
+	auditLog *audit.Log
+
+	// Starts signing the audit log the first time it is opened
+	startAuditSigning sync.Once
+
+	// Keeps the auction data from being written by two goroutines at once
+	persistMut sync.Mutex
+
+	// Update, highest term and vote last persisted, guarded by mut
+	persistedTerm        int64
+	persistedSequence    uint64
+	persistedHighestTerm int64
+	persistedVotedTerm   int64
+}
+
+// newReplica creates a replica with the given settings, reporting every setting that is invalid.
+// The data directory defaults to data/<id> and is claimed for the replica, so no other replica can use it,
+// and the audit signing key defaults to keys/<id>/audit.key.
+func newReplica(id, clientAddr, readAddr, replicationListenAddr, dataDir, auditKeyPath string) (*replica, error) {
+	r := &replica{
+		id:                    id,
+		clientAddr:            clientAddr,
+		readAddr:              readAddr,
+		replicationListenAddr: replicationListenAddr,
+		dataDir:               dataDir,
+		auditKeyPath:          auditKeyPath,
+		healthServer:          health.NewServer(),
+		stopped:               make(chan struct{}),
+		backups:               make(map[string]*backupReplica),
+		applied:               make(chan struct{}),
+		takeovers:             make(chan *pb.TakeOverRequest, 1),
+		bidderLimiters:        newRateLimiters(*bidderRate, *bidderBurst),
+		connectionLimiters:    newRateLimiters(*connectionRate, *connectionBurst),
+		globalLimiters:        newRateLimiters(*globalRate, *globalBurst),
+	}
+
+	errs := []error{
+		config.CheckAddress("client-addr", r.clientAddr),
+		config.CheckAddress("read-addr", r.readAddr),
+	}
+	if r.replicationListenAddr != "" {
+		errs = append(errs, config.CheckAddress("replication-addr", r.replicationListenAddr))
+	}
+	if err := r.loadMembers(); err != nil {
+		errs = append(errs, err)
+	} else if r.clientAddr == r.replicationAddr() {
+		errs = append(errs, errors.New("-client-addr and the replication address must be different"))
+	}
+
+	if r.dataDir == "" {
+		r.dataDir = filepath.Join("data", r.id)
+	}
+	if err := os.MkdirAll(r.dataDir, 0755); err != nil {
+		errs = append(errs, errors.New("-data-dir: "+err.Error()))
+	} else if err := r.claimDataDir(); err != nil {
+		errs = append(errs, errors.New("-data-dir: "+err.Error()))
+	}
+
+	if r.auditKeyPath == "" {
+		r.auditKeyPath = filepath.Join("keys", r.id, "audit.key")
+	}
+	if inside, err := within(r.auditKeyPath, r.dataDir); err != nil {
+		errs = append(errs, errors.New("-audit-key: "+err.Error()))
+	} else if inside {
+		errs = append(errs, errors.New("-audit-key must be outside the data directory, or the audit log could be rewritten and signed again from it"))
+	} else if err := os.MkdirAll(filepath.Dir(r.auditKeyPath), 0700); err != nil {
+		errs = append(errs, errors.New("-audit-key: "+err.Error()))
+	}
+
+	return r, errors.Join(errs...)
+}
+
+// start restores the auction data persisted before the replica was restarted, if any, and starts serving
+// health checks, reads and replication. The replica starts out as a backup until run.
+func (r *replica) start() error {
+	// Initializes auction with default values, or with the data persisted before the replica was restarted
+	r.auctionServer = &AuctionServer{}
+	restored, err := r.restoreState()
+	if err != nil {
+		return fmt.Errorf("restoring auction data from %s, remove it to start without it: %w", r.dataPath(stateFile), err)
+	}
+	// The peer list only makes up the cluster until it has been replicated and changed, after which a replica
+	// restarted under another ID would never find itself in the cluster
+	if len(r.auctionServer.Members) == 0 {
+		r.auctionServer.Members = r.configuredMembers
+	} else if !slices.ContainsFunc(r.auctionServer.Members, func(m Member) bool { return m.Id == r.id }) {
+		return fmt.Errorf("replica %s is not one of the replicas %s of the cluster restored from %s, restart it with the -id it had, or remove the file if it was removed from the cluster or is still being added", r.id, memberIds(r.auctionServer.Members), r.dataPath(stateFile))
+	}
+	r.setRole("backup")
+
+	r.writeToLogAndTerminal("Starting new replica...")
+	if restored {
+		r.writeToLogAndTerminal("Restored auction data up to update "+strconv.FormatUint(r.auctionServer.Sequence, 10)+", rejoining as a backup", "sequence", r.auctionServer.Sequence, "highest_term", r.highestTerm)
+	}
+	go r.persistPeriodically()
+	go r.forgetIdleLimiters()
+
+	// Health is served separately from the client address, so backups can be checked as well
+	healthAddr, err := r.serveHealth(*healthListenAddr)
+	if err != nil {
+		return fmt.Errorf("serving health checks: %w", err)
+	}
+	r.writeToLogAndTerminal("Serving health checks on "+healthAddr.String(), "health_addr", healthAddr.String())
+
+	// Every replica serves reads on its own address, whatever its role
+	readAddr, err := r.serveReads(r.readAddr)
+	if err != nil {
+		return fmt.Errorf("serving reads: %w", err)
+	}
+	r.writeToLogAndTerminal("Serving reads on "+readAddr.String(), "read_addr", readAddr.String())
+
+	// Every replica receives auction data, votes and registrations on its own replication address
+	listenAddr, err := r.serveReplication(r.replicationAddr())
+	if err != nil {
+		return fmt.Errorf("serving replication: %w", err)
+	}
+	r.writeToLogAndTerminal("Serving replication on "+listenAddr.String()+" as replica "+r.id+" of "+strconv.Itoa(len(r.auctionServer.Members)), "replication_addr", listenAddr.String(), "cluster_size", len(r.auctionServer.Members))
+	return nil
+}
+
+// run follows the primary, stands for election and leads once elected until the replica is shut down,
+// or the primary it leads as reaches the end of the terminal commands.
+// Replicas start out as backups and stand for election once their failure detector has declared the primary
+// dead, or right away when the primary hands over to them. Losing means another replica was elected, which is
+// then followed instead, as is the new primary after stepping down.
+func (r *replica) run(commands <-chan string) {
+	for {
+		takeover := r.followPrimary()
+		if r.isStopped() {
+			return
+		}
+
+		var term int64
+		if takeover != nil {
+			r.writeToLogAndTerminal("Taking over as primary replica from "+takeover.PrimaryId, "primary", takeover.PrimaryId)
+			term = r.standForElection(true)
+		} else {
+			r.writeToLogAndTerminal("Standing for election as primary replica")
+			term = r.standForElection(false)
+		}
+		if term == 0 {
+			continue
+		}
+
+		if !r.lead(term, commands) {
+			return
+		}
+	}
+}
+
+// shutdown stops serving and sending updates to backups, and persists the auction data one last time
+func (r *replica) shutdown() {
+	r.stopOnce.Do(func() {
+		r.writeToLogAndTerminal("Stopping gRPC server...")
+		close(r.stopped)
+		r.healthServer.Shutdown()
+
+		r.mut.Lock()
+		clientServer := r.clientServer
+		r.mut.Unlock()
+		if clientServer != nil {
+			clientServer.GracefulStop()
+		}
+		for _, server := range []*grpc.Server{r.replicationServer, r.readServer, r.healthCheckServer} {
+			if server != nil {
+				server.Stop()
+			}
+		}
+
+		r.mut.Lock()
+		for _, backup := range r.backups {
+			backup.close()
+		}
+		r.closeAuditLog()
+		r.mut.Unlock()
+		if err := r.persistState(); err != nil {
+			r.writeErrorToLogAndTerminal("Error persisting auction data", err)
+		}
+	})
+}
+
+// isStopped reports whether the replica has been shut down
+func (r *replica) isStopped() bool {
+	select {
+	case <-r.stopped:
+		return true
+	default:
+		return false
+	}
+}
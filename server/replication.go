@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/Juules32/Auction/money"
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Number of recent updates the primary keeps for backups that are only slightly behind.
+// Backups further behind are sent a snapshot instead.
+const maxRecentUpdates = 256
+
+// resetUpdates starts a new update history when becoming primary, as backups will need a snapshot
+// of the new term anyway. Must be called with mut held.
+func (r *replica) resetUpdates() {
+	r.recentUpdates = nil
+	r.replicatedLedgerLength = len(r.auctionServer.Ledger)
+	r.unreplicatedBids = nil
+}
+
+// recordUpdate assigns the next sequence number to the changes made since the previous update
+// and keeps the update for backups to fetch. Must be called with mut held.
+func (r *replica) recordUpdate() {
+	s := r.auctionServer
+	s.Sequence++
+
+	update := &pb.StateUpdate{Sequence: s.Sequence, Status: s.status()}
+	for _, entry := range s.Ledger[r.replicatedLedgerLength:] {
+		update.NewLedgerEntries = append(update.NewLedgerEntries, entry.record())
+	}
+	r.replicatedLedgerLength = len(s.Ledger)
+	for _, requestId := range r.unreplicatedBids {
+		if outcome, ok := s.ProcessedBids[requestId]; ok {
+			update.NewProcessedBids = append(update.NewProcessedBids, outcome.processedBid(requestId))
+		}
+	}
+	r.unreplicatedBids = nil
+
+	r.recentUpdates = append(r.recentUpdates, update)
+	if len(r.recentUpdates) > maxRecentUpdates {
+		r.recentUpdates = r.recentUpdates[len(r.recentUpdates)-maxRecentUpdates:]
+	}
+}
+
+// pendingFor returns what a backup is missing given the last update it applied and the term it came from:
+// nothing if it is up to date, the missing updates if they are all still kept, and a snapshot otherwise.
+// Must be called with mut held.
+func (r *replica) pendingFor(term int64, sequence uint64) ([]*pb.StateUpdate, *pb.AuctionSnapshot) {
+	s := r.auctionServer
+	if term == s.Term && sequence == s.Sequence {
+		return nil, nil
+	}
+
+	// Updates of other terms came from another primary and may differ from ours even with the same sequence number
+	if term == s.Term && sequence < s.Sequence && len(r.recentUpdates) > 0 && r.recentUpdates[0].Sequence <= sequence+1 {
+		return r.recentUpdates[sequence+1-r.recentUpdates[0].Sequence:], nil
+	}
+	return nil, s.snapshot()
+}
+
+// snapshot captures the complete auction data
+func (s *AuctionServer) snapshot() *pb.AuctionSnapshot {
+	snapshot := &pb.AuctionSnapshot{Sequence: s.Sequence, Status: s.status()}
+	for _, entry := range s.Ledger {
+		snapshot.Ledger = append(snapshot.Ledger, entry.record())
+	}
+	for _, requestId := range s.ProcessedBidOrder {
+		snapshot.ProcessedBids = append(snapshot.ProcessedBids, s.ProcessedBids[requestId].processedBid(requestId))
+	}
+	return snapshot
+}
+
+// auctionServerFromSnapshot restores auction data captured by snapshot
+func auctionServerFromSnapshot(snapshot *pb.AuctionSnapshot) *AuctionServer {
+	s := &AuctionServer{Sequence: snapshot.Sequence}
+	s.applyStatus(snapshot.Status)
+	for _, record := range snapshot.Ledger {
+		s.Ledger = append(s.Ledger, ledgerEntry(record))
+	}
+	for _, bid := range snapshot.ProcessedBids {
+		s.addProcessedBid(bid)
+	}
+	return s
+}
+
+// applyUpdate applies the next update to the auction data
+func (s *AuctionServer) applyUpdate(update *pb.StateUpdate) error {
+	if update.Sequence != s.Sequence+1 {
+		return fmt.Errorf("update %d does not follow update %d", update.Sequence, s.Sequence)
+	}
+
+	s.applyStatus(update.Status)
+	for _, record := range update.NewLedgerEntries {
+		s.Ledger = append(s.Ledger, ledgerEntry(record))
+	}
+	for _, bid := range update.NewProcessedBids {
+		s.addProcessedBid(bid)
+	}
+	s.Sequence = update.Sequence
+	return nil
+}
+
+// status captures the auction data besides the ledger and processed bids
+func (s *AuctionServer) status() *pb.AuctionStatus {
+	return &pb.AuctionStatus{
+		AuctionId:          s.AuctionId,
+		ItemName:           s.ItemName,
+		IsActive:           s.IsActive,
+		BaseCurrency:       s.BaseCurrency,
+		MinimumBid:         s.MinimumBid.Proto(),
+		HighestBidOriginal: s.HighestBid.Original.Proto(),
+		HighestBid:         s.HighestBid.Converted.Proto(),
+		HighestBidRate:     s.HighestBid.Rate,
+		Term:               s.Term,
+		UpdatedAt:          timestamppb.New(s.UpdatedAt),
+		AuditHeads:         auditHeadsProto(s.AuditHeads),
+		Members:            membersProto(s.Members),
+		RelocatedAuctions:  maps.Clone(s.RelocatedAuctions),
+		LastAuctionId:      s.LastAuctionId,
+	}
+}
+
+// applyStatus replaces the auction data besides the ledger and processed bids
+func (s *AuctionServer) applyStatus(status *pb.AuctionStatus) {
+	s.AuctionId = status.AuctionId
+	s.ItemName = status.ItemName
+	s.IsActive = status.IsActive
+	s.BaseCurrency = status.BaseCurrency
+	s.MinimumBid = money.FromProto(status.MinimumBid)
+	s.HighestBid = money.Conversion{
+		Original:  money.FromProto(status.HighestBidOriginal),
+		Converted: money.FromProto(status.HighestBid),
+		Rate:      status.HighestBidRate,
+	}
+	s.Term = status.Term
+	s.UpdatedAt = status.UpdatedAt.AsTime()
+	s.AuditHeads = auditHeadsFromProto(status.AuditHeads)
+	s.Members = membersFromProto(status.Members)
+	s.RelocatedAuctions = status.RelocatedAuctions
+	s.LastAuctionId = status.LastAuctionId
+}
+
+// processedBid converts a stored bid outcome to its protobuf representation
+func (o BidOutcome) processedBid(requestId string) *pb.ProcessedBid {
+	bid := &pb.ProcessedBid{
+		RequestId:    requestId,
+		Amount:       o.Amount.Proto(),
+		Success:      o.Success,
+		Reason:       o.Reason,
+		Message:      o.Message,
+		ExchangeRate: o.ExchangeRate,
+	}
+	if o.ConvertedAmount != nil {
+		bid.ConvertedAmount = o.ConvertedAmount.Proto()
+	}
+	return bid
+}
+
+// addProcessedBid stores a replicated bid outcome for deduplication
+func (s *AuctionServer) addProcessedBid(bid *pb.ProcessedBid) {
+	outcome := BidOutcome{
+		Amount:       money.FromProto(bid.Amount),
+		Success:      bid.Success,
+		Reason:       bid.Reason,
+		Message:      bid.Message,
+		ExchangeRate: bid.ExchangeRate,
+	}
+	if bid.ConvertedAmount != nil {
+		converted := money.FromProto(bid.ConvertedAmount)
+		outcome.ConvertedAmount = &converted
+	}
+	s.storeProcessedBid(bid.RequestId, outcome)
+}
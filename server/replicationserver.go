@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Juules32/Auction/config"
+	"github.com/Juules32/Auction/failure"
+	pb "github.com/Juules32/Auction/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReplicationServer implements the Replication gRPC service, which every replica serves on its own replication
+// address: backups to receive updates and votes, and the primary for backups to register.
+type ReplicationServer struct {
+	*replica
+}
+
+// Version of the replication protocol, which backups give when registering. The primary refuses backups of other
+// versions rather than sending them data they would misread.
+const replicationProtocolVersion = 1
+
+// Largest message replicas accept from each other, as snapshots of long ledgers exceed the gRPC default of 4 MB
+const maxReplicationMessageSize = 64 << 20
+
+// newFailureDetector creates the failure detector selected by the flags. The phi detector tolerates
+// pauses up to the failure timeout before its suspicion starts rising.
+func newFailureDetector() failure.Detector {
+	if *failureDetector == "phi" {
+		return failure.NewPhiAccrualDetector(*phiThreshold, *heartbeatInterval, *failureTimeout-*heartbeatInterval)
+	}
+	return failure.NewTimeoutDetector(*failureTimeout)
+}
+
+// earliestFailure returns the shortest time without contact after which the failure detector of a backup can
+// declare the primary dead. The phi detector does so before the failure timeout when heartbeats are regular.
+func earliestFailure() time.Duration {
+	if *failureDetector == "phi" {
+		return failure.EarliestPhiFailure(*phiThreshold, *heartbeatInterval, *failureTimeout-*heartbeatInterval)
+	}
+	return *failureTimeout
+}
+
+// heardFromPrimary feeds the failure detector. Must be called with mut held.
+func (r *replica) heardFromPrimary() {
+	if r.primaryDetector != nil {
+		r.primaryDetector.Heartbeat(time.Now())
+		r.primaryHeard = true
+	}
+}
+
+// serveReplication starts the Replication service on the replication address, returning the address it listens on
+func (r *replica) serveReplication(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r.replicationServer = grpc.NewServer(append(replicationServerCredentials(), grpc.StatsHandler(otelgrpc.NewServerHandler()), grpc.MaxRecvMsgSize(maxReplicationMessageSize))...)
+	pb.RegisterReplicationServer(r.replicationServer, &ReplicationServer{r})
+	go func() {
+		if err := r.replicationServer.Serve(listener); err != nil {
+			r.writeErrorToLogAndTerminal("Error serving replication", err)
+		}
+	}()
+	return listener.Addr(), nil
+}
+
+// Register implements the Register RPC method
+func (r *ReplicationServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	if req.ReplicaId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing replica ID")
+	}
+	if err := config.CheckAddress("address", req.Address); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.ProtocolVersion != replicationProtocolVersion {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("backup speaks replication protocol version %d, but the primary speaks version %d", req.ProtocolVersion, replicationProtocolVersion))
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	// A backup with data of a later term has followed a newer primary
+	if req.Term > r.highestTerm {
+		r.checkTerm(req.Term)
+	}
+	if r.currentRole != "primary" {
+		return nil, status.Error(codes.FailedPrecondition, "not the primary")
+	}
+	if err := r.registerBackup(req); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	r.writeToLogAndTerminal("Backup replica "+req.ReplicaId+" registered from "+req.Address, "backup", req.ReplicaId, "backup_addr", req.Address, "sequence", req.Sequence)
+	return &pb.RegisterResponse{PrimaryId: r.id, Term: r.auctionServer.Term}, nil
+}
+
+// AppendState implements the AppendState RPC method
+func (r *ReplicationServer) AppendState(ctx context.Context, req *pb.AppendStateRequest) (*pb.ReplicationResponse, error) {
+	r.mut.Lock()
+	if err := r.checkTerm(req.Term); err != nil {
+		r.mut.Unlock()
+		return nil, err
+	}
+	if r.currentRole == "primary" {
+		r.mut.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, "not a backup")
+	}
+	r.heardFromPrimary()
+
+	// Updates that don't follow on from the last one applied make the primary send a snapshot instead
+	for _, update := range req.Updates {
+		if err := r.auctionServer.applyUpdate(update); err != nil {
+			r.observeBackupInSync(false)
+			r.mut.Unlock()
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+	r.receivedAuctionData()
+	response := r.replicationResponse()
+	r.mut.Unlock()
+	return r.acknowledge(response)
+}
+
+// Snapshot implements the Snapshot RPC method
+func (r *ReplicationServer) Snapshot(ctx context.Context, req *pb.AuctionSnapshot) (*pb.ReplicationResponse, error) {
+	r.mut.Lock()
+	if err := r.checkTerm(req.Status.GetTerm()); err != nil {
+		r.mut.Unlock()
+		return nil, err
+	}
+	if r.currentRole == "primary" {
+		r.mut.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, "not a backup")
+	}
+	r.heardFromPrimary()
+
+	r.auctionServer = auctionServerFromSnapshot(req)
+	r.receivedAuctionData()
+	response := r.replicationResponse()
+	r.mut.Unlock()
+	return r.acknowledge(response)
+}
+
+// Heartbeat implements the Heartbeat RPC method
+func (r *ReplicationServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.ReplicationResponse, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if err := r.checkTerm(req.Term); err != nil {
+		return nil, err
+	}
+	if r.currentRole == "primary" {
+		return nil, status.Error(codes.FailedPrecondition, "not a backup")
+	}
+	r.heardFromPrimary()
+
+	// The primary sends the missing updates after seeing the response
+	inSync := req.Term == r.auctionServer.Term && req.Sequence == r.auctionServer.Sequence
+	r.caughtUp = r.caughtUp || inSync
+	r.observeBackupInSync(inSync)
+	return r.replicationResponse(), nil
+}
+
+// TakeOver implements the TakeOver RPC method
+func (r *ReplicationServer) TakeOver(ctx context.Context, req *pb.TakeOverRequest) (*pb.ReplicationResponse, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if err := r.checkTerm(req.Term); err != nil {
+		return nil, err
+	}
+	if r.currentRole == "primary" {
+		return nil, status.Error(codes.FailedPrecondition, "not a backup")
+	}
+	r.heardFromPrimary()
+
+	// Nothing may be lost in the handover
+	if r.auctionServer.Term != req.Term || r.auctionServer.Sequence != req.Sequence {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("has update %d of term %d rather than update %d of term %d", r.auctionServer.Sequence, r.auctionServer.Term, req.Sequence, req.Term))
+	}
+
+	select {
+	case r.takeovers <- req:
+	default:
+		return nil, status.Error(codes.FailedPrecondition, "already taking over")
+	}
+	return r.replicationResponse(), nil
+}
+
+// receivedAuctionData records that the backup has caught up with the primary. Must be called with mut held.
+func (r *replica) receivedAuctionData() {
+	r.setRole("backup")
+	r.caughtUp = true
+	r.observeBackupInSync(true)
+	replicationLagSeconds.Set(time.Since(r.auctionServer.UpdatedAt).Seconds())
+	r.writeToLogAndTerminal("Backup replica receives auction data from primary replica: "+r.auctionDataString(), "auction", r.auctionServer.AuctionId, "sequence", r.auctionServer.Sequence)
+}
+
+// acknowledge persists the auction data received from the primary before the backup acknowledges it. The
+// acknowledgement counts toward the quorum the primary answers clients after, so a backup restarted with data
+// older than it has acknowledged could vote for a primary missing an update a client was told about.
+func (r *replica) acknowledge(response *pb.ReplicationResponse) (*pb.ReplicationResponse, error) {
+	if err := r.persistState(); err != nil {
+		r.writeErrorToLogAndTerminal("Error persisting auction data", err)
+		return nil, status.Error(codes.Unavailable, "could not persist the auction data")
+	}
+	return response, nil
+}
+
+// replicationResponse describes the data held by this backup. Must be called with mut held.
+func (r *replica) replicationResponse() *pb.ReplicationResponse {
+	return &pb.ReplicationResponse{Sequence: r.auctionServer.Sequence, Term: r.auctionServer.Term}
+}
+
+// followPrimary registers this backup with the primary and follows it until the failure detector declares it dead,
+// retrying the registration every heartbeat interval until it succeeds, or until the primary hands over to this
+// backup, in which case the handover is returned. The detector starts out as if the primary had just been heard from,
+// so a primary that is starting up or only briefly unreachable isn't taken over from.
+// A replica that is a cluster of its own has no primary to follow, and one that is shut down stops following.
+func (r *replica) followPrimary() *pb.TakeOverRequest {
+	r.mut.Lock()
+	alone := r.isMember() && len(r.auctionServer.Members) == 1
+	r.mut.Unlock()
+	if alone {
+		return nil
+	}
+
+	r.mut.Lock()
+	r.primaryDetector, r.primaryHeard = newFailureDetector(), false
+	r.mut.Unlock()
+
+	// Handovers accepted while not following were meant for an earlier primary
+	select {
+	case <-r.takeovers:
+	default:
+	}
+
+	check := time.NewTicker(*heartbeatInterval / 4)
+	defer check.Stop()
+	retry := time.NewTicker(*heartbeatInterval)
+	defer retry.Stop()
+
+	registered := r.tryRegisterWithPrimary()
+	for {
+		select {
+		case <-r.stopped:
+			return nil
+		case takeover := <-r.takeovers:
+			return takeover
+		case <-retry.C:
+			if !registered {
+				registered = r.tryRegisterWithPrimary()
+			}
+		case now := <-check.C:
+			r.mut.Lock()
+			suspicion, failed := r.primaryDetector.Suspicion(now), r.primaryDetector.Failed(now)
+			member := r.isMember()
+			eligible := (r.caughtUp || !registered) && member
+			if failed && !eligible {
+				r.primaryDetector, r.primaryHeard = newFailureDetector(), false
+			}
+			r.mut.Unlock()
+			primarySuspicion.Set(suspicion)
+
+			// Registering again finds the backup that took over, or if there is none,
+			// the primary is declared dead again and this backup stands for election after all.
+			// Replicas that aren't part of the cluster, such as ones still being added, never stand.
+			if failed && !eligible {
+				if member {
+					r.writeToLogAndTerminal("Primary replica declared dead before this backup caught up, leaving the election to backups that have")
+				} else {
+					r.writeToLogAndTerminal("Primary replica declared dead, leaving the election to the replicas of the cluster as this one isn't part of it")
+				}
+				registered = r.tryRegisterWithPrimary()
+				continue
+			}
+			if failed {
+				primaryFailuresDetectedTotal.Inc()
+				r.observeBackupInSync(false)
+				r.writeToLogAndTerminal("Primary replica declared dead by the "+*failureDetector+" failure detector", "detector", *failureDetector, "suspicion", suspicion, "registered", registered)
+				return nil
+			}
+		}
+	}
+}
+
+// tryRegisterWithPrimary registers with the primary, reporting whether it succeeded
+func (r *replica) tryRegisterWithPrimary() bool {
+	if err := r.registerWithPrimary(); err != nil {
+		r.observeBackupInSync(false)
+		r.logger.Load().Warn("Error registering with primary replica", "error", err)
+		return false
+	}
+	return true
+}
+
+// registerWithPrimary registers with the first other replica that accepts it, which is the primary
+func (r *replica) registerWithPrimary() error {
+	r.mut.Lock()
+	request := &pb.RegisterRequest{
+		ReplicaId: r.id,
+		Address:   r.advertisedAddr,
+		Sequence:  r.auctionServer.Sequence,
+		Term:      r.auctionServer.Term,
+
+		ProtocolVersion: replicationProtocolVersion,
+	}
+	others := r.otherMembers()
+	r.mut.Unlock()
+
+	err := errors.New("no other replicas to register with")
+	for _, p := range others {
+		var conn *grpc.ClientConn
+		conn, err = dialReplica(p.Address)
+		if err != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *replicationTimeout)
+		var response *pb.RegisterResponse
+		response, err = pb.NewReplicationClient(conn).Register(ctx, request)
+		cancel()
+		conn.Close()
+		if err != nil {
+			continue
+		}
+
+		// A primary of an older term than one already seen has been deposed, even if it doesn't know yet
+		r.mut.Lock()
+		stale := response.Term < r.highestTerm
+		r.highestTerm = max(r.highestTerm, response.Term)
+		r.mut.Unlock()
+		if stale {
+			err = fmt.Errorf("primary replica %s is of stale term %d", response.PrimaryId, response.Term)
+			continue
+		}
+
+		// Counts as contact so the primary has time to send its data
+		r.mut.Lock()
+		r.heardFromPrimary()
+		r.mut.Unlock()
+		r.writeToLogAndTerminal("Registered with primary replica "+response.PrimaryId, "primary", response.PrimaryId, "primary_term", response.Term)
+		return nil
+	}
+	return err
+}
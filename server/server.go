@@ -3,18 +3,30 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"net"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
+	"github.com/Juules32/Auction/logging"
+	"github.com/Juules32/Auction/metrics"
+	"github.com/Juules32/Auction/money"
 	pb "github.com/Juules32/Auction/proto"
+	"github.com/Juules32/Auction/tlsconfig"
+	"github.com/Juules32/Auction/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 // Template auction items for flavor
@@ -52,209 +64,645 @@ var templateAuctionItemNames = []string{
 
 // AuctionServer implements the Auction gRPC service
 type AuctionServer struct {
-	HighestBid int32  `json:"HighestBid"`
-	MinimumBid int32  `json:"MinimumBid"`
-	IsActive   bool   `json:"IsActive"`
-	ItemName   string `json:"ItemName"`
+	HighestBid   money.Conversion `json:"HighestBid"`
+	MinimumBid   money.Money      `json:"MinimumBid"`
+	BaseCurrency string           `json:"BaseCurrency"`
+	IsActive     bool             `json:"IsActive"`
+	ItemName     string           `json:"ItemName"`
+
+	// Auction the data above belongs to, allocated when it is started or kept from the group it was moved from
+	AuctionId int64 `json:"AuctionId"`
+
+	// Last auction ID this group allocated, which auctions moved in from other groups don't change
+	LastAuctionId int64 `json:"LastAuctionId"`
+
+	// Replica group each auction moved into or out of this group was last moved to, by auction ID
+	RelocatedAuctions map[int64]string `json:"RelocatedAuctions"`
+
+	// Incremented every time a replica becomes primary
+	Term int64 `json:"Term"`
+
+	// Every bid attempt in the order it was processed
+	Ledger []LedgerEntry `json:"Ledger"`
+
+	// When the primary last changed the auction data
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	// Sequence number of the last update replicated to backups
+	Sequence uint64 `json:"Sequence"`
+
+	// Head of the audit log of every replica that has been primary, by replica ID. Each replica only writes
+	// to its own log, so the head of a log is known to the replicas even while another replica is primary.
+	AuditHeads map[string]AuditHead `json:"AuditHeads"`
+
+	// Replicas of the cluster ordered by ID, replicated so every replica counts quorums the same way
+	Members []Member `json:"Members"`
+
+	// Responses to recent bids by request ID, oldest first in ProcessedBidOrder,
+	// replicated so that retries after a failover are not applied twice
+	ProcessedBids     map[string]BidOutcome `json:"ProcessedBids"`
+	ProcessedBidOrder []string              `json:"ProcessedBidOrder"`
+}
+
+// BidOutcome is the stored response to a processed bid, along with the amount
+// that was bid so reuse of a request ID for a different bid can be detected
+type BidOutcome struct {
+	Amount          money.Money        `json:"Amount"`
+	Success         bool               `json:"Success"`
+	Reason          pb.RejectionReason `json:"Reason,omitempty"`
+	Message         string             `json:"Message"`
+	ConvertedAmount *money.Money       `json:"ConvertedAmount,omitempty"`
+	ExchangeRate    string             `json:"ExchangeRate,omitempty"`
 }
 
-// Struct used to save and update information about the auction
-var auctionServer *AuctionServer
-var serverListener net.Listener
-var mut sync.Mutex
+// Identifies this replica in logs
+var replicaId = flag.String("id", "r1", "ID of this replica, which must stay the same across restarts")
+var logOptions = logging.RegisterFlags()
+var metricsListenAddr = flag.String("metrics-addr", "localhost:0", "address to serve Prometheus metrics on")
+var healthListenAddr = flag.String("health-addr", "localhost:0", "address to serve gRPC health checks on")
+var traceOptions = tracing.RegisterFlags()
+var tlsFiles = tlsconfig.RegisterFlags()
+var authKeyPath = flag.String("auth-key", "", "public key verifying client tokens, enables authentication along with mutual TLS between replicas, which it requires (e.g. auth.key.pub)")
+
+var tracer = otel.Tracer("github.com/Juules32/Auction/server")
 
 // Bid implements the Bid RPC method
-func (s *AuctionServer) Bid(ctx context.Context, req *pb.BidRequest) (*pb.BidResponse, error) {
-	mut.Lock()
-	defer mut.Unlock()
+func (r *replica) Bid(ctx context.Context, req *pb.BidRequest) (*pb.BidResponse, error) {
+	if err := authenticateBidder(ctx, req); err != nil {
+		return nil, err
+	}
 
-	// There must be an active auction
-	if !s.IsActive {
-		return &pb.BidResponse{Success: false, Message: "Auction inactive!"}, nil
+	response, committing, err := r.bid(ctx, req)
+	if err != nil || !committing {
+		return response, err
 	}
 
-	// The amount must be higher than the highest bid
-	// or higher or equal to the minimum bid
-	if req.Amount <= s.HighestBid || req.Amount < s.MinimumBid {
-		return &pb.BidResponse{Success: false, Message: "Bid too low"}, nil
+	// The bid is only acknowledged once a quorum has applied it, so it survives failover
+	if err := r.waitForQuorum(ctx, response.Term, response.Sequence); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// bid processes a bid, reporting whether the response reflects an update a quorum has to apply before it is sent
+func (r *replica) bid(ctx context.Context, req *pb.BidRequest) (*pb.BidResponse, bool, error) {
+	// Waiting for the lock gets its own span, so contention shows up in traces
+	_, lockSpan := tracer.Start(ctx, "acquire auction lock")
+	r.mut.Lock()
+	lockSpan.End()
+	defer r.mut.Unlock()
+	s := r.auctionServer
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("auction.id", s.AuctionId), attribute.String("auction.bidder", req.Bidder), attribute.String("auction.request_id", req.RequestId))
+
+	// A primary that has stepped down must not accept bids the new primary doesn't know about,
+	// and one handing over must not accept bids the backup would have to catch up on
+	if !r.acceptsWrites() {
+		outcome := rejectBid(pb.RejectionReason_NOT_LEADER, "Not the primary replica")
+		observeBid(outcome)
+		return outcome.response(s.Term, s.Sequence), false, nil
 	}
 
-	s.HighestBid = req.Amount
-	go handleBackupReplicas(serverListener)
-	return &pb.BidResponse{Success: true, Message: "Bid successful"}, nil
+	// Retries of an already processed bid get the original response
+	if outcome, ok := s.ProcessedBids[req.RequestId]; ok && req.RequestId != "" {
+		if outcome.Amount != money.FromProto(req.Amount) {
+			duplicate := rejectBid(pb.RejectionReason_DUPLICATE, "Request ID already used for a different bid")
+			observeBid(duplicate)
+			r.appendToLedger(req, duplicate)
+			r.replicateState(ctx)
+			return duplicate.response(s.Term, s.Sequence), true, nil
+		}
+		r.writeToLogAndTerminal("Server received retry of bid "+req.RequestId, "auction", s.AuctionId, "bidder", req.Bidder, "request_id", req.RequestId)
+		return outcome.response(s.Term, s.Sequence), true, nil
+	}
+
+	// Bids in an auction hosted by another replica group are redirected there without being recorded
+	if group := s.hostOf(req.AuctionId); group != "" {
+		return s.wrongGroupResponse(req.AuctionId, group), false, nil
+	}
+
+	// Infrastructure failures are returned as errors and not remembered, so retries are processed again
+	outcome, err := r.placeBid(req)
+	if err != nil {
+		return nil, false, err
+	}
+	outcome.Amount = money.FromProto(req.Amount)
+	span.SetAttributes(attribute.Bool("auction.bid_accepted", outcome.Success), attribute.String("auction.rejection_reason", outcome.Reason.String()))
+	observeBid(outcome)
+	r.appendToLedger(req, outcome)
+	r.rememberBid(ctx, req.RequestId, outcome)
+	return outcome.response(s.Term, s.Sequence), true, nil
 }
 
-// Result implements the Result RPC method
-func (s *AuctionServer) Result(ctx context.Context, req *pb.ResultRequest) (*pb.ResultResponse, error) {
-	mut.Lock()
-	defer mut.Unlock()
+// placeBid validates a bid and updates the highest bid if it is accepted
+func (r *replica) placeBid(req *pb.BidRequest) (BidOutcome, error) {
+	s := r.auctionServer
+
+	// There must be an active auction, and it must be the one bid in
+	if !s.IsActive || (req.AuctionId != 0 && req.AuctionId != s.AuctionId) {
+		return rejectBid(pb.RejectionReason_AUCTION_INACTIVE, "Auction inactive!"), nil
+	}
 
-	return &pb.ResultResponse{IsActive: s.IsActive, HighestBid: int32(s.HighestBid)}, nil
+	amount := money.FromProto(req.Amount)
+	if amount.Units <= 0 {
+		return rejectBid(pb.RejectionReason_INVALID_AMOUNT, "Bid must be a positive amount"), nil
+	}
+
+	currency, err := money.NormalizeCurrency(amount.Currency)
+	if err != nil {
+		return rejectBid(pb.RejectionReason_UNSUPPORTED_CURRENCY, "Invalid currency"), nil
+	}
+	amount.Currency = currency
+
+	// Bids are compared in the base currency using the exchange rate at the time of bidding
+	rates, err := r.currentFxRates()
+	if err != nil && currency != s.BaseCurrency {
+		return BidOutcome{}, infrastructureError(grpccodes.Unavailable, "FX_RATES_UNAVAILABLE", "exchange rates could not be loaded: "+err.Error())
+	}
+	conversion, err := rates.Convert(amount, s.BaseCurrency)
+	if err != nil {
+		return rejectBid(pb.RejectionReason_UNSUPPORTED_CURRENCY, "Cannot convert bid: "+err.Error()), nil
+	}
+	converted := conversion.Converted
+
+	// The amount must be higher or equal to the minimum bid
+	// and higher than the highest bid
+	if converted.Units < s.MinimumBid.Units {
+		outcome := rejectBid(pb.RejectionReason_BELOW_MINIMUM, "Bid below minimum of "+s.MinimumBid.String())
+		outcome.ConvertedAmount, outcome.ExchangeRate = &converted, conversion.Rate
+		return outcome, nil
+	}
+	if converted.Units <= s.HighestBid.Converted.Units {
+		outcome := rejectBid(pb.RejectionReason_TOO_LOW, "Bid too low")
+		outcome.ConvertedAmount, outcome.ExchangeRate = &converted, conversion.Rate
+		return outcome, nil
+	}
+
+	s.HighestBid = conversion
+	r.writeToLogAndTerminal("Server accepted bid of "+conversion.String()+" from "+req.Bidder, "auction", s.AuctionId, "bidder", req.Bidder, "request_id", req.RequestId)
+	return BidOutcome{Success: true, Message: "Bid successful", ConvertedAmount: &converted, ExchangeRate: conversion.Rate}, nil
 }
 
-func main() {
-	writeToLogAndTerminal("Starting new replica...")
+// rejectBid creates the outcome of a rejected bid
+func rejectBid(reason pb.RejectionReason, message string) BidOutcome {
+	return BidOutcome{Success: false, Reason: reason, Message: message}
+}
 
-	// Starts grpc server
-	server := grpc.NewServer()
+// rememberBid stores the outcome of a bid for deduplication and replicates it,
+// along with the ledger entry of the bid
+func (r *replica) rememberBid(ctx context.Context, requestId string, outcome BidOutcome) {
+	if requestId != "" {
+		r.auctionServer.storeProcessedBid(requestId, outcome)
+		r.unreplicatedBids = append(r.unreplicatedBids, requestId)
+	}
 
-	// Initializes auction with default values
-	auctionServer = &AuctionServer{}
+	r.replicateState(ctx)
+}
 
-	// Backup replicas go through this for loop until one becomes leader
-	for {
-		if becomesLeader() {
-			break
-		}
-		receiveAuctionDataFromPrimaryReplica()
+// storeProcessedBid stores the outcome of a bid, forgetting the oldest request IDs once the table is full
+func (s *AuctionServer) storeProcessedBid(requestId string, outcome BidOutcome) {
+	if s.ProcessedBids == nil {
+		s.ProcessedBids = make(map[string]BidOutcome)
 	}
+	s.ProcessedBids[requestId] = outcome
+	s.ProcessedBidOrder = append(s.ProcessedBidOrder, requestId)
 
-	// Initializes the server listener on port 5050
-	var err error
-	serverListener, err = net.Listen("tcp", "localhost:5050")
+	for len(s.ProcessedBidOrder) > *maxProcessedBids {
+		delete(s.ProcessedBids, s.ProcessedBidOrder[0])
+		s.ProcessedBidOrder = s.ProcessedBidOrder[1:]
+	}
+}
+
+// response converts the stored outcome to a BidResponse from the primary of the given term and update
+func (o BidOutcome) response(term int64, sequence uint64) *pb.BidResponse {
+	response := &pb.BidResponse{Success: o.Success, Reason: o.Reason, Message: o.Message, ExchangeRate: o.ExchangeRate, Term: term, Sequence: sequence}
+	if o.ConvertedAmount != nil {
+		response.ConvertedAmount = o.ConvertedAmount.Proto()
+	}
+	return response
+}
+
+// Result implements the Result RPC method, serving the result as up to date as the request asks for
+func (r *replica) Result(ctx context.Context, req *pb.ResultRequest) (*pb.ResultResponse, error) {
+	consistency, err := readConsistency(req.Consistency)
 	if err != nil {
-		fmt.Println("Error starting server:", err)
-		return
+		return nil, err
+	}
+	if consistency == pb.ReadConsistency_LINEARIZABLE {
+		if err := r.confirmLeadership(ctx); err != nil {
+			return nil, infrastructureError(grpccodes.Unavailable, "LEADERSHIP_UNCONFIRMED", "the primary could not confirm it is still primary: "+err.Error())
+		}
 	}
-	defer serverListener.Close()
 
-	// Handles grpc requests from clients
-	go serveClients(server)
+	response, err := r.result(req, consistency)
+	if err != nil || consistency == pb.ReadConsistency_STALE {
+		return response, err
+	}
 
-	// Handles text input from the terminal to perform various tasks
-	takeInputs(server)
+	// Only results a quorum has applied are returned, as the next primary may not have seen the others
+	if err := r.waitForQuorum(ctx, response.Term, response.Sequence); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// readConsistency returns the consistency a result is served at, which is the lease if the client didn't choose one
+func readConsistency(consistency pb.ReadConsistency) (pb.ReadConsistency, error) {
+	switch consistency {
+	case pb.ReadConsistency_READ_CONSISTENCY_UNSPECIFIED:
+		return pb.ReadConsistency_LEASE, nil
+	case pb.ReadConsistency_LEASE, pb.ReadConsistency_LINEARIZABLE, pb.ReadConsistency_STALE:
+		return consistency, nil
+	default:
+		return 0, status.Errorf(grpccodes.InvalidArgument, "unknown read consistency %d", consistency)
+	}
+}
+
+// result looks up the result of an auction at the consistency asked for
+func (r *replica) result(req *pb.ResultRequest, consistency pb.ReadConsistency) (*pb.ResultResponse, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s := r.auctionServer
+
+	resultCallsTotal.Inc()
+
+	if consistency != pb.ReadConsistency_STALE && r.currentRole != "primary" {
+		return nil, status.Error(grpccodes.FailedPrecondition, "not the primary")
+	}
+	if consistency == pb.ReadConsistency_LEASE && !r.holdsLease() {
+		leaseExpiredReadsTotal.Inc()
+		return nil, infrastructureError(grpccodes.Unavailable, "LEASE_EXPIRED", "the primary's lease has expired, so it may have been replaced")
+	}
+	if err := s.checkFreshness(req.MinTerm, req.MinSequence); err != nil {
+		return nil, err
+	}
+	if group := s.hostOf(req.AuctionId); group != "" {
+		auctionId := req.AuctionId
+		if auctionId == 0 {
+			auctionId = s.AuctionId
+		}
+		return nil, wrongGroupError(auctionId, group)
+	}
+	if req.AuctionId != 0 && req.AuctionId != s.AuctionId {
+		return nil, status.Error(grpccodes.NotFound, "only the result of the group's latest auction is kept, earlier auctions can be looked up in the bid history")
+	}
 
+	return &pb.ResultResponse{
+		IsActive:           s.IsActive,
+		AuctionId:          s.AuctionId,
+		ItemName:           s.ItemName,
+		HighestBid:         s.HighestBid.Converted.Proto(),
+		HighestBidOriginal: s.HighestBid.Original.Proto(),
+		ExchangeRate:       s.HighestBid.Rate,
+		Term:               s.Term,
+		Sequence:           s.Sequence,
+	}, nil
 }
 
-func becomesLeader() bool {
-	// Tries to become primary replica by acquiring the 5050 port used for server communication
-	serverListener, err := net.Listen("tcp", "localhost:5050")
+// currentFxRates returns the exchange rate table, reloading it if the file has changed.
+// Returns nil if no rate file exists, in which case only base currency bids convert.
+// If the file can't be loaded the previous table is kept, and an error is only
+// returned if there is no previous table to fall back on.
+func (r *replica) currentFxRates() (*money.RateTable, error) {
+	info, err := os.Stat(*fxRatesPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return r.fxRates, nil
+	}
+	if err == nil && r.fxRates != nil && info.ModTime().Equal(r.fxRatesModTime) {
+		return r.fxRates, nil
+	}
+
+	var rates *money.RateTable
+	if err == nil {
+		rates, err = money.LoadRates(*fxRatesPath)
+	}
 	if err != nil {
-		return false
+		r.writeErrorToLogAndTerminal("Error loading exchange rates", err)
+		if r.fxRates == nil {
+			return nil, err
+		}
+		return r.fxRates, nil
 	}
-	serverListener.Close()
-	writeToLogAndTerminal("Primary replica has been found")
-	return true
+	r.fxRates = rates
+	r.fxRatesModTime = info.ModTime()
+	r.writeToLogAndTerminal("Loaded exchange rates from "+*fxRatesPath, "base", rates.Base)
+	return r.fxRates, nil
 }
 
-func receiveAuctionDataFromPrimaryReplica() {
-	// Tries to dial up the primary replica
-	conn, err := net.Dial("tcp", "localhost:5050")
+func main() {
+	flag.Parse()
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	r, err := newReplica(*replicaId, *clientListenAddr, *readListenAddr, *replicationListenAddr, *dataDir, *auditKeyPath)
 	if err != nil {
-		fmt.Println(err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	// Receives JSON data from the primary replica
-	responseBuffer := make([]byte, 1024)
-	n, err := conn.Read(responseBuffer)
+	r.baseLogger, err = logging.New(*logOptions, "server", r.id)
 	if err != nil {
-		fmt.Println("Error reading JSON data:", err)
-		return
+		log.Fatalf("Error setting up logging: %v", err)
 	}
 
-	// Decodes JSON data into AuctionServer struct
-	var receivedAuctionServer AuctionServer
-	err = json.Unmarshal(responseBuffer[:n], &receivedAuctionServer)
+	shutdownTracing, err := tracing.Setup(*traceOptions, "server", r.id)
 	if err != nil {
-		fmt.Println("Error decoding JSON:", err)
-		return
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Exposes metrics on the configured address, which picks a free port by default so replicas don't collide
+	metricsAddr, err := metrics.Serve(*metricsListenAddr)
+	if err != nil {
+		log.Fatalf("Error serving metrics: %v", err)
+	}
+
+	if err := setupTLS(*tlsFiles, r.baseLogger); err != nil {
+		log.Fatalf("Error loading TLS certificates: %v", err)
+	}
+
+	if err := setupAuth(*authKeyPath); err != nil {
+		log.Fatalf("Error loading authentication key: %v", err)
 	}
 
-	// Sets the received struct as the struct
-	auctionServer = &receivedAuctionServer
-	writeToLogAndTerminal("Backup replica receives auction data from primary replica: " + auctionDataString())
+	if err := r.start(); err != nil {
+		log.Fatalf("Error starting replica %s: %v", r.id, err)
+	}
+	r.writeToLogAndTerminal("Serving metrics on http://"+metricsAddr.String()+"/metrics", "metrics_addr", metricsAddr.String())
 
-	conn.Close()
+	// Terminal commands are read in the background, so a primary can step down while waiting for one
+	r.run(readCommands())
 }
 
-func serveClients(server *grpc.Server) {
-	clientListener, err := net.Listen("tcp", "localhost:8080")
+// lead serves as primary of the term won in the election on the client address until the replica is shut down,
+// returning false, or steps down, returning true once the client address is released
+func (r *replica) lead(term int64, commands <-chan string) bool {
+	server := r.newClientServer()
 
-	pb.RegisterAuctionServer(server, auctionServer)
+	r.mut.Lock()
+	if r.isStopped() {
+		r.mut.Unlock()
+		return false
+	}
+	// A later term may have started since the election was won
+	if term != r.highestTerm {
+		r.mut.Unlock()
+		return true
+	}
+	r.startTerm(term)
+	r.resetUpdates()
+	r.membershipSequence = r.auctionServer.Sequence
+	r.setRole("primary")
+	r.clientServer = server
+	steppedDown := r.deposed
+	r.mut.Unlock()
+	leaderChangesTotal.Inc()
 
-	// Continually serves client requests
+	r.openAuditLog()
+
+	// Handles grpc requests from clients
+	r.serveClients(server, r.clientAddr)
+
+	// Handles text input from the terminal to perform various tasks
+	if !r.takeInputs(commands, steppedDown) {
+		return false
+	}
+
+	// Calls in progress, such as the one that handed over leadership, are given time to finish,
+	// while the listener is closed right away
+	stopped := make(chan struct{})
 	go func() {
-		err = server.Serve(clientListener)
-		if err != nil {
-			fmt.Println("Error serving listener:", err)
-		}
+		server.GracefulStop()
+		close(stopped)
 	}()
-
-	writeToLogAndTerminal("Server is running on localhost:8080")
+	select {
+	case <-stopped:
+	case <-time.After(*replicationTimeout):
+		server.Stop()
+	}
+	r.mut.Lock()
+	r.clientServer = nil
+	r.mut.Unlock()
+	return true
 }
 
-func handleBackupReplicas(serverListener net.Listener) {
+// newClientServer creates the gRPC server clients are served by while this replica is primary.
+// Calls are authorized and rate limited after being measured, so rejected calls show up in the metrics.
+// Rate limiting comes after authorization so bidders are identified by their token.
+func (r *replica) newClientServer() *grpc.Server {
+	return grpc.NewServer(append(append(clientCredentials(),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(metricsInterceptor),
+	), append(authInterceptors(), grpc.ChainUnaryInterceptor(r.rateLimitInterceptor))...)...)
+}
 
-	// Accepts dial
-	conn, err := serverListener.Accept()
-	if err != nil {
-		fmt.Println("Error accepting connection:", err)
-		return
+// listenWithin listens on the address, retrying until the timeout in case it is still being released
+func listenWithin(addr string, timeout time.Duration) (net.Listener, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		listener, err := net.Listen("tcp", addr)
+		if err == nil || time.Now().After(deadline) {
+			return listener, err
+		}
+		time.Sleep(transferPollInterval)
 	}
-	defer conn.Close()
+}
 
-	// Encodes the struct to JSON
-	jsonData, err := json.Marshal(auctionServer)
+// serveClients serves clients on the address, which a primary on the same host may still be releasing
+func (r *replica) serveClients(server *grpc.Server, addr string) {
+	clientListener, err := listenWithin(addr, *replicationTimeout)
 	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
+		r.writeErrorToLogAndTerminal("Error listening for clients on "+addr, err)
 		return
 	}
 
-	// Sends encoded data back
-	_, err = conn.Write(jsonData)
-	if err != nil {
-		fmt.Println("Error sending response:", err)
-		return
-	}
+	pb.RegisterAuctionServer(server, r)
+	healthpb.RegisterHealthServer(server, r.healthServer)
+
+	// Continually serves client requests
+	go func() {
+		err = server.Serve(clientListener)
+		if err != nil {
+			r.writeErrorToLogAndTerminal("Error serving listener", err)
+		}
+	}()
+
+	r.writeToLogAndTerminal("Server is running on "+addr, "client_addr", addr)
+}
 
+// replicateState marks the auction data as updated, records the changes as the next update and wakes
+// the senders of the backups, continuing the trace of ctx. Must be called with mut held.
+func (r *replica) replicateState(ctx context.Context) {
+	r.auctionServer.UpdatedAt = time.Now()
+	r.recordUpdate()
+	r.notifyBackups(ctx)
 }
 
-func takeInputs(server *grpc.Server) {
-	scanner := bufio.NewScanner(os.Stdin)
+// readCommands reads lines from the terminal in the background, closing the channel at the end of the input
+func readCommands() <-chan string {
+	commands := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			commands <- scanner.Text()
+		}
+		close(commands)
+	}()
+	return commands
+}
+
+// takeInputs handles terminal commands until the primary steps down, returning true,
+// or is shut down by the 'crash' command or otherwise, or reaches the end of the input, returning false
+func (r *replica) takeInputs(commands <-chan string, steppedDown <-chan struct{}) bool {
 	fmt.Println("Enter command:")
-	for scanner.Scan() {
-		command := scanner.Text()
+	for {
+		var line string
+		select {
+		case <-r.stopped:
+			return false
+		case <-steppedDown:
+			return true
+		case command, ok := <-commands:
+			if !ok {
+				return false
+			}
+			line = command
+		}
+
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
 
-		switch strings.ToLower(command) {
+		switch strings.ToLower(words[0]) {
 		case "start":
-			mut.Lock()
-			auctionServer.HighestBid = 0
-			auctionServer.MinimumBid = int32(rand.Intn(100))
-			auctionServer.ItemName = templateAuctionItemNames[rand.Intn(len(templateAuctionItemNames)-1)]
-			auctionServer.IsActive = true
-			go handleBackupReplicas(serverListener)
-			writeToLogAndTerminal("Server started new auction for " + auctionServer.ItemName + " starting at " + strconv.Itoa(int(auctionServer.MinimumBid)) + " dollars")
-			mut.Unlock()
+			if authKey != nil {
+				fmt.Println("Authentication is enabled, auctions are started by auctioneers with the client's 'start' command")
+				continue
+			}
+
+			// The base currency of the auction can optionally be given, e.g. 'start EUR'
+			currency := *defaultCurrency
+			if len(words) > 1 {
+				var err error
+				currency, err = money.NormalizeCurrency(words[1])
+				if err != nil {
+					fmt.Println("Invalid currency:", err)
+					continue
+				}
+			}
+
+			r.mut.Lock()
+			if r.acceptsWrites() {
+				r.startAuction(context.Background(), currency, "terminal")
+			} else {
+				fmt.Println("The auction was not started:", status.Convert(notAcceptingWritesError()).Message())
+			}
+			r.mut.Unlock()
 		case "end":
-			auctionServer.IsActive = false
-			go handleBackupReplicas(serverListener)
-			writeToLogAndTerminal("Server ended auction with winning bid " + strconv.Itoa(int(auctionServer.HighestBid)))
+			if authKey != nil {
+				fmt.Println("Authentication is enabled, auctions are ended by auctioneers with the client's 'end' command")
+				continue
+			}
+
+			r.mut.Lock()
+			if r.acceptsWrites() {
+				r.endAuction(context.Background(), "terminal")
+			} else {
+				fmt.Println("The auction was not ended:", status.Convert(notAcceptingWritesError()).Message())
+			}
+			r.mut.Unlock()
+		case "transfer-leadership":
+			if authKey != nil {
+				fmt.Println("Authentication is enabled, leadership is transferred by auctioneers with the client's 'transfer-leadership' command")
+				continue
+			}
+
+			// The backup to hand over to can optionally be given, e.g. 'transfer-leadership r2'
+			var target string
+			if len(words) > 1 {
+				target = words[1]
+			}
+			if _, err := r.transferLeadership(context.Background(), target, "terminal"); err != nil {
+				fmt.Println("Leadership was not transferred:", status.Convert(err).Message())
+			}
+		case "add", "remove":
+			if authKey != nil {
+				fmt.Println("Authentication is enabled, replicas are added and removed by auctioneers with the client's 'add' and 'remove' commands")
+				continue
+			}
+
+			// The replica is given by ID, e.g. 'add r4', and must have registered with the primary to be added
+			if len(words) < 2 {
+				fmt.Println("Usage: '" + words[0] + " <replica id>'")
+				continue
+			}
+			change := r.addReplica
+			if strings.ToLower(words[0]) == "remove" {
+				change = r.removeReplica
+			}
+			if _, err := change(context.Background(), words[1], "terminal"); err != nil {
+				fmt.Println("The replicas of the cluster were not changed:", status.Convert(err).Message())
+			}
+		case "move":
+			if authKey != nil {
+				fmt.Println("Authentication is enabled, auctions are moved by auctioneers with the client's 'move' command")
+				continue
+			}
+
+			// The active auction is moved to the group given by ID, e.g. 'move b'
+			if len(words) < 2 {
+				fmt.Println("Usage: 'move <group id>'")
+				continue
+			}
+			if _, err := r.moveAuction(context.Background(), 0, words[1], "terminal"); err != nil {
+				fmt.Println("The auction was not moved:", status.Convert(err).Message())
+			}
+		case "members":
+			r.mut.Lock()
+			fmt.Println(r.describeMembers())
+			r.mut.Unlock()
 		case "crash":
-			writeToLogAndTerminal("Stopping gRPC server...")
-			server.GracefulStop()
-			return
+			r.shutdown()
+			return false
 		case "print":
-			writeToLogAndTerminal(auctionDataString())
+			r.mut.Lock()
+			data := r.auctionDataString()
+			r.mut.Unlock()
+			r.writeToLogAndTerminal(data)
+		case "verify":
+			r.verifyAuditLog()
 		default:
-			fmt.Println("Invalid command. Valid commands: 'start', 'end', 'crash', 'print'")
+			fmt.Println("Invalid command. Valid commands: 'start [currency]', 'end', 'transfer-leadership [replica id]', 'add <replica id>', 'remove <replica id>', 'move <group id>', 'members', 'crash', 'print', 'verify'")
 		}
 	}
 }
 
-func auctionDataString() string {
-	return strconv.Itoa(int(auctionServer.HighestBid)) + " " + strconv.Itoa(int(auctionServer.MinimumBid)) + " " + strconv.FormatBool(auctionServer.IsActive) + " " + auctionServer.ItemName
+// auctionDataString describes the auction data for the terminal. Must be called with mut held.
+func (r *replica) auctionDataString() string {
+	return r.auctionServer.HighestBid.String() + " " + r.auctionServer.MinimumBid.String() + " " + strconv.FormatBool(r.auctionServer.IsActive) + " " + r.auctionServer.ItemName
 }
 
-func writeToLogAndTerminal(message string) {
-	fmt.Println(message)
+// setRole attaches the role of the replica and the term of its auction data to every following log record,
+// and updates the health of the replica if the role changed
+func (r *replica) setRole(role string) {
+	r.logger.Store(r.baseLogger.With("role", role, "term", r.auctionServer.Term))
+	observeRole(role, r.auctionServer.Term)
 
-	f, err := os.OpenFile("log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+	if role != r.currentRole {
+		r.currentRole = role
+		r.observeHealthRole(role)
 	}
-	log.SetOutput(f)
+}
 
-	log.Println(message)
+// writeToLogAndTerminal prints the message and logs it along with any additional key-value fields
+func (r *replica) writeToLogAndTerminal(message string, fields ...any) {
+	fmt.Println(message)
+	r.logger.Load().Info(message, fields...)
+}
 
-	defer f.Close()
+// writeErrorToLogAndTerminal prints and logs a message describing an error
+func (r *replica) writeErrorToLogAndTerminal(message string, err error, fields ...any) {
+	fmt.Println(message+":", err)
+	r.logger.Load().Error(message, append(fields, "error", err)...)
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/Juules32/Auction/money"
+	pb "github.com/Juules32/Auction/proto"
+	"github.com/Juules32/Auction/shards"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Auctions can be partitioned across replica groups, each a cluster with its own primary, so they aren't all
+// serialized by one primary. Every group allocates the auction IDs of its own residue class in the shard map,
+// so calls about an auction go to the group that started it, which redirects them with WRONG_GROUP if it has
+// since been moved. An auction is moved by closing it to bids in its group and handing it, bids and
+// all, to the primary of the other group, which makes it its current auction.
+
+// Groups auctions are partitioned across, nil if this cluster hosts every auction itself.
+// Set when loading the configuration.
+var shardMap *shards.Map
+
+// loadShards reads the shard map if one is given, which must list the group of this replica
+func loadShards() error {
+	if *shardsPath == "" {
+		if *groupId != "" {
+			return errors.New("-group requires -shards")
+		}
+		return nil
+	}
+
+	var err error
+	shardMap, err = shards.Load(*shardsPath)
+	if err != nil {
+		return errors.New("-shards: " + err.Error())
+	}
+	if _, ok := shardMap.Addresses(*groupId); !ok {
+		return errors.New("-group " + *groupId + " is not one of the groups in the shard map")
+	}
+	return nil
+}
+
+// nextAuctionId allocates the ID of a new auction of this group. IDs follow both the last one the group allocated
+// and that of its current auction, which may have been moved in from another group. Must be called with mut held.
+func (r *replica) nextAuctionId() int64 {
+	after := max(r.auctionServer.LastAuctionId, r.auctionServer.AuctionId)
+	if shardMap == nil {
+		return after + 1
+	}
+	return shardMap.NextAuctionId(*groupId, after)
+}
+
+// hostOf returns the replica group hosting an auction if it isn't this one, or "" if this group hosts it or
+// hosted it last. Auction 0 is the current auction of the group. Must be called with mut held.
+func (s *AuctionServer) hostOf(auctionId int64) string {
+	if auctionId == 0 {
+		auctionId = s.AuctionId
+	}
+	group, relocated := s.RelocatedAuctions[auctionId]
+	if !relocated && shardMap != nil {
+		group = shardMap.Owner(auctionId)
+	}
+	if group == *groupId {
+		return ""
+	}
+	return group
+}
+
+// wrongGroupResponse rejects a bid in an auction hosted by another replica group, naming the group to send it to.
+// Must be called with mut held.
+func (s *AuctionServer) wrongGroupResponse(auctionId int64, group string) *pb.BidResponse {
+	if auctionId == 0 {
+		auctionId = s.AuctionId
+	}
+	outcome := rejectBid(pb.RejectionReason_WRONG_GROUP, "Auction "+strconv.FormatInt(auctionId, 10)+" is hosted by replica group "+group)
+	observeBid(outcome)
+	response := outcome.response(s.Term, s.Sequence)
+	response.AuctionId, response.Group = auctionId, group
+	return response
+}
+
+// relocate records the group an auction was moved to. Must be called with mut held.
+func (s *AuctionServer) relocate(auctionId int64, group string) {
+	if s.RelocatedAuctions == nil {
+		s.RelocatedAuctions = make(map[int64]string)
+	}
+	s.RelocatedAuctions[auctionId] = group
+}
+
+// MoveAuction implements the MoveAuction RPC method
+func (r *replica) MoveAuction(ctx context.Context, req *pb.MoveAuctionRequest) (*pb.MoveAuctionResponse, error) {
+	return r.moveAuction(ctx, req.AuctionId, req.Group, caller(ctx))
+}
+
+// moveAuction moves the active auction of this group to another group on behalf of the operator. Bids are
+// rejected from the moment the move starts, so the other group gets every bid. If the other group refuses the
+// auction it carries on here, while if it can't be reached it may have taken the auction already, so the move
+// has to be retried.
+func (r *replica) moveAuction(ctx context.Context, auctionId int64, group string, operator string) (*pb.MoveAuctionResponse, error) {
+	if shardMap == nil {
+		return nil, status.Error(codes.FailedPrecondition, "auctions can only be moved between the replica groups of a shard map")
+	}
+	addrs, ok := shardMap.Addresses(group)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "replica group "+group+" is not in the shard map")
+	}
+	if group == *groupId {
+		return nil, status.Error(codes.InvalidArgument, "the auction is already hosted by replica group "+group)
+	}
+
+	r.mut.Lock()
+	if !r.acceptsWrites() {
+		r.mut.Unlock()
+		return nil, notAcceptingWritesError()
+	}
+	s := r.auctionServer
+	if auctionId == 0 {
+		auctionId = s.AuctionId
+	}
+	retry := auctionId == s.AuctionId && s.RelocatedAuctions[auctionId] == group
+	if !retry && (auctionId != s.AuctionId || !s.IsActive) {
+		r.mut.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, "only the active auction of the group can be moved")
+	}
+	if !retry {
+		s.IsActive = false
+		s.relocate(auctionId, group)
+		r.recordAuditEvent("auction_moved", map[string]string{
+			"AuctionId":  strconv.FormatInt(auctionId, 10),
+			"Group":      group,
+			"HighestBid": s.HighestBid.String(),
+			"Operator":   operator,
+		})
+		r.replicateState(ctx)
+	}
+	request := s.importRequest()
+	response := &pb.MoveAuctionResponse{AuctionId: auctionId, Group: group, Term: s.Term, Sequence: s.Sequence}
+	r.mut.Unlock()
+
+	// The auction is only handed over once closing it here survives failover, or both groups could host it
+	if err := r.waitForQuorum(ctx, response.Term, response.Sequence); err != nil {
+		return nil, err
+	}
+
+	// A retried move that already reached the other group is done
+	if err := importAuction(ctx, addrs, request); err != nil && status.Code(err) != codes.AlreadyExists {
+		if !refused(err) {
+			return nil, status.Error(codes.Unavailable, "replica group "+group+" could not be reached, it may have taken the auction already, so retry the move: "+status.Convert(err).Message())
+		}
+
+		r.mut.Lock()
+		s := r.auctionServer
+		if r.acceptsWrites() && s.AuctionId == auctionId && s.RelocatedAuctions[auctionId] == group {
+			s.IsActive = true
+			s.relocate(auctionId, *groupId)
+			r.recordAuditEvent("auction_move_refused", map[string]string{
+				"AuctionId": strconv.FormatInt(auctionId, 10),
+				"Group":     group,
+				"Operator":  operator,
+			})
+			r.replicateState(ctx)
+		}
+		r.mut.Unlock()
+		return nil, status.Error(status.Code(err), "replica group "+group+" refused the auction, which carries on here: "+status.Convert(err).Message())
+	}
+
+	auctionMovesTotal.WithLabelValues("out").Inc()
+	r.writeToLogAndTerminal("Moved auction "+strconv.FormatInt(auctionId, 10)+" to replica group "+group, "auction", auctionId, "group", group, "operator", operator)
+	return response, nil
+}
+
+// importRequest captures the current auction and its bids for moving it to another group. Must be called with mut held.
+func (s *AuctionServer) importRequest() *pb.ImportAuctionRequest {
+	request := &pb.ImportAuctionRequest{
+		AuctionId:          s.AuctionId,
+		ItemName:           s.ItemName,
+		BaseCurrency:       s.BaseCurrency,
+		MinimumBid:         s.MinimumBid.Proto(),
+		HighestBid:         s.HighestBid.Converted.Proto(),
+		HighestBidOriginal: s.HighestBid.Original.Proto(),
+		ExchangeRate:       s.HighestBid.Rate,
+		SourceGroup:        *groupId,
+	}
+	for _, entry := range s.Ledger {
+		if entry.AuctionId == s.AuctionId {
+			request.Bids = append(request.Bids, entry.record())
+		}
+	}
+	return request
+}
+
+// importAuction hands an auction to the primary of another group, trying each of its replicas in turn.
+// The call is made with the token of the operator moving the auction, if any.
+func importAuction(ctx context.Context, addrs []string, request *pb.ImportAuctionRequest) error {
+	if tokens := metadata.ValueFromIncomingContext(ctx, "authorization"); len(tokens) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tokens[0])
+	}
+	ctx, cancel := context.WithTimeout(ctx, *replicationTimeout)
+	defer cancel()
+
+	var err error
+	for _, addr := range addrs {
+		var conn *grpc.ClientConn
+		conn, err = dialGroupReplica(addr)
+		if err != nil {
+			continue
+		}
+		_, err = pb.NewAuctionClient(conn).ImportAuction(ctx, request)
+		conn.Close()
+
+		// Only the primary of the group listens on its client address
+		if status.Code(err) != codes.Unavailable {
+			return err
+		}
+	}
+	return err
+}
+
+// refused reports whether the other group answered a move by refusing the auction, rather than possibly taking it
+func refused(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.Unknown, codes.Internal:
+		return false
+	default:
+		return true
+	}
+}
+
+// ImportAuction implements the ImportAuction RPC method, making an auction moved from another group the current
+// auction of this one. An auction that has already been moved here is rejected with ALREADY_EXISTS, even once it
+// has ended, so a retried move never brings it or its bids back a second time.
+func (r *replica) ImportAuction(ctx context.Context, req *pb.ImportAuctionRequest) (*pb.ImportAuctionResponse, error) {
+	if shardMap == nil {
+		return nil, status.Error(codes.FailedPrecondition, "this cluster is not a replica group of a shard map")
+	}
+	if req.SourceGroup == *groupId {
+		return nil, status.Error(codes.InvalidArgument, "the auction is already hosted by replica group "+*groupId)
+	}
+
+	response, err := r.takeOver(ctx, req)
+	if response == nil {
+		return nil, err
+	}
+
+	// The other group only lets go of the auction once it survives failover here, which a repeated import
+	// waits for as well, as the first may not have been acknowledged
+	if err := r.waitForQuorum(ctx, response.Term, response.Sequence); err != nil {
+		return nil, err
+	}
+	return response, err
+}
+
+// takeOver makes an auction moved from another group the current auction of this one. An import of an auction
+// that was already moved here fails with ALREADY_EXISTS, but still returns the update to wait for.
+func (r *replica) takeOver(ctx context.Context, req *pb.ImportAuctionRequest) (*pb.ImportAuctionResponse, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	s := r.auctionServer
+
+	if !r.acceptsWrites() {
+		return nil, notAcceptingWritesError()
+	}
+	if s.RelocatedAuctions[req.AuctionId] == *groupId {
+		return &pb.ImportAuctionResponse{Term: s.Term, Sequence: s.Sequence}, status.Error(codes.AlreadyExists, "auction "+strconv.FormatInt(req.AuctionId, 10)+" has already been moved to replica group "+*groupId)
+	}
+	if s.IsActive {
+		return nil, status.Error(codes.FailedPrecondition, "replica group "+*groupId+" already has an active auction, which has to end first")
+	}
+
+	s.AuctionId = req.AuctionId
+	s.ItemName = req.ItemName
+	s.BaseCurrency = req.BaseCurrency
+	s.MinimumBid = money.FromProto(req.MinimumBid)
+	s.HighestBid = money.Conversion{
+		Original:  money.FromProto(req.HighestBidOriginal),
+		Converted: money.FromProto(req.HighestBid),
+		Rate:      req.ExchangeRate,
+	}
+	s.IsActive = true
+	s.relocate(req.AuctionId, *groupId)
+
+	// The bids keep their order but are numbered by their position in this group's ledger
+	for _, record := range req.Bids {
+		entry := ledgerEntry(record)
+		entry.Sequence = int64(len(s.Ledger)) + 1
+		s.Ledger = append(s.Ledger, entry)
+	}
+
+	operator := caller(ctx)
+	r.recordAuditEvent("auction_imported", map[string]string{
+		"AuctionId":   strconv.FormatInt(req.AuctionId, 10),
+		"SourceGroup": req.SourceGroup,
+		"ItemName":    s.ItemName,
+		"HighestBid":  s.HighestBid.String(),
+		"Bids":        strconv.Itoa(len(req.Bids)),
+		"Operator":    operator,
+	})
+	r.replicateState(ctx)
+	auctionMovesTotal.WithLabelValues("in").Inc()
+	r.writeToLogAndTerminal("Took over auction "+strconv.FormatInt(req.AuctionId, 10)+" for "+s.ItemName+" from replica group "+req.SourceGroup+" with highest bid "+s.HighestBid.String(), "auction", req.AuctionId, "source_group", req.SourceGroup, "operator", operator)
+	return &pb.ImportAuctionResponse{Term: s.Term, Sequence: s.Sequence}, nil
+}
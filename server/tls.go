@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/Juules32/Auction/tlsconfig"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Certificates of the replica, nil if TLS is disabled
+var tlsReloader *tlsconfig.Reloader
+
+// setupTLS loads the configured certificates, if any, logging errors reloading them
+func setupTLS(files tlsconfig.Files, logger *slog.Logger) error {
+	if files.Cert == "" && files.Key == "" && files.CA == "" {
+		return nil
+	}
+
+	var err error
+	tlsReloader, err = tlsconfig.NewReloader(files)
+	if err != nil {
+		return err
+	}
+	tlsReloader.OnReloadError = func(err error) {
+		fmt.Println("Error reloading TLS certificates, keeping the previous ones:", err)
+		logger.Error("Error reloading TLS certificates, keeping the previous ones", "error", err)
+	}
+	return nil
+}
+
+// clientCredentials returns the options securing the client-facing gRPC server with TLS when enabled
+func clientCredentials() []grpc.ServerOption {
+	if tlsReloader == nil || tlsFiles.Cert == "" {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsReloader.ServerConfig()))}
+}
+
+// replicationUsesTLS reports whether replicas authenticate each other with mutual TLS,
+// which requires both a certificate and the CA that signed the other replicas' certificates
+func replicationUsesTLS() bool {
+	return tlsReloader != nil && tlsFiles.Cert != "" && tlsFiles.CA != ""
+}
+
+// replicationServerCredentials returns the options requiring other replicas to present a certificate when TLS is enabled
+func replicationServerCredentials() []grpc.ServerOption {
+	if !replicationUsesTLS() {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsReloader.MutualServerConfig()))}
+}
+
+// dialReplica connects to another replica, verifying it and presenting our certificate when TLS is enabled
+func dialReplica(addr string) (*grpc.ClientConn, error) {
+	transportCredentials := insecure.NewCredentials()
+	if replicationUsesTLS() {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		transportCredentials = credentials.NewTLS(tlsReloader.ClientConfig(host))
+	}
+	return grpc.Dial(addr, grpc.WithTransportCredentials(transportCredentials), grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxReplicationMessageSize)))
+}
+
+// dialGroupReplica connects to a replica of another group on its client address, verifying it with the CA when one is configured
+func dialGroupReplica(addr string) (*grpc.ClientConn, error) {
+	transportCredentials := insecure.NewCredentials()
+	if tlsReloader != nil && tlsFiles.CA != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		transportCredentials = credentials.NewTLS(tlsReloader.ClientConfig(host))
+	}
+	return grpc.Dial(addr, grpc.WithTransportCredentials(transportCredentials), grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+}
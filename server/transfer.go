@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	pb "github.com/Juules32/Auction/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// How often the primary checks whether the backup it is handing over to has caught up
+const transferPollInterval = 10 * time.Millisecond
+
+// acceptsWrites reports whether this replica may change the auction data. Must be called with mut held.
+func (r *replica) acceptsWrites() bool {
+	return r.currentRole == "primary" && !r.transferring
+}
+
+// notAcceptingWritesError is returned for changes to the auction data made while acceptsWrites is false
+func notAcceptingWritesError() error {
+	return status.Error(codes.FailedPrecondition, "not the primary, or leadership is being transferred")
+}
+
+// TransferLeadership implements the TransferLeadership RPC method
+func (r *replica) TransferLeadership(ctx context.Context, req *pb.TransferLeadershipRequest) (*pb.TransferLeadershipResponse, error) {
+	return r.transferLeadership(ctx, req.ReplicaId, caller(ctx))
+}
+
+// transferLeadership hands over to a backup on behalf of the operator: bids are rejected until the backup has
+// caught up and agreed to stand for election right away, after which this primary steps down and releases the
+// client address. If the handover fails, this primary carries on accepting bids.
+func (r *replica) transferLeadership(ctx context.Context, targetId string, operator string) (*pb.TransferLeadershipResponse, error) {
+	r.mut.Lock()
+	if !r.acceptsWrites() {
+		r.mut.Unlock()
+		return nil, notAcceptingWritesError()
+	}
+	target := r.transferTarget(targetId)
+	if target == nil {
+		r.mut.Unlock()
+		return nil, status.Error(codes.NotFound, "no registered backup "+targetId)
+	}
+	r.transferring = true
+	term := r.auctionServer.Term
+	r.mut.Unlock()
+
+	defer func() {
+		r.mut.Lock()
+		r.transferring = false
+		r.mut.Unlock()
+	}()
+
+	r.writeToLogAndTerminal("Transferring leadership to backup replica "+target.id, "backup", target.id, "operator", operator)
+
+	// No more updates are made, so the backup catches up as soon as it has applied the last one
+	var takeover *pb.TakeOverRequest
+	deadline := time.Now().Add(*replicationTimeout)
+	for takeover == nil {
+		r.mut.Lock()
+		if target.term == r.auctionServer.Term && target.sequence == r.auctionServer.Sequence {
+			takeover = &pb.TakeOverRequest{PrimaryId: r.id, Term: r.auctionServer.Term, Sequence: r.auctionServer.Sequence}
+		}
+		closed := target.closed
+		r.mut.Unlock()
+
+		switch {
+		case takeover != nil:
+		case closed:
+			return nil, r.transferFailed(target, status.Error(codes.Unavailable, "the backup is no longer registered"))
+		case time.Now().After(deadline):
+			return nil, r.transferFailed(target, status.Error(codes.DeadlineExceeded, "the backup did not catch up in time"))
+		default:
+			target.notify(ctx)
+			time.Sleep(transferPollInterval)
+		}
+	}
+
+	takeoverCtx, cancel := context.WithTimeout(ctx, *replicationTimeout)
+	defer cancel()
+	if _, err := target.client.TakeOver(takeoverCtx, takeover); err != nil {
+		return nil, r.transferFailed(target, status.Error(codes.Unavailable, "the backup did not take over: "+status.Convert(err).Message()))
+	}
+
+	r.mut.Lock()
+	r.recordAuditEvent("leadership_transferred", map[string]string{
+		"From":     r.id,
+		"To":       target.id,
+		"Term":     strconv.FormatInt(term, 10),
+		"Operator": operator,
+	})
+	r.stepDown("leadership was handed over to backup " + target.id)
+	r.mut.Unlock()
+	leadershipTransfersTotal.WithLabelValues("success").Inc()
+
+	return &pb.TransferLeadershipResponse{ReplicaId: target.id, Term: term}, nil
+}
+
+// transferTarget returns the backup to hand over to, by default the one furthest ahead. Must be called with mut held.
+func (r *replica) transferTarget(replicaId string) *backupReplica {
+	// Replicas that are still being added can't be elected
+	if replicaId != "" {
+		if r.findMember(replicaId) == nil {
+			return nil
+		}
+		return r.backups[replicaId]
+	}
+
+	var target *backupReplica
+	for _, backup := range r.backups {
+		if r.findMember(backup.id) == nil {
+			continue
+		}
+		if target == nil || backup.term > target.term || (backup.term == target.term && backup.sequence > target.sequence) {
+			target = backup
+		}
+	}
+	return target
+}
+
+// transferFailed logs a failed handover, after which this primary resumes accepting bids
+func (r *replica) transferFailed(target *backupReplica, err error) error {
+	leadershipTransfersTotal.WithLabelValues("failure").Inc()
+	r.writeErrorToLogAndTerminal("Error transferring leadership to backup replica "+target.id+", carrying on as primary", err, "backup", target.id)
+	return err
+}
@@ -0,0 +1,108 @@
+package shards
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// Map partitions auctions across independent replica groups, each a cluster of its own with its own primary.
+// Every group allocates the auction IDs of its own residue class, so the group that started an auction can be
+// told from its ID alone, and groups that an auction was moved away from redirect calls to where it went.
+type Map struct {
+	// Group IDs in order, which decides the auction IDs each group allocates
+	groups []string
+
+	// Client addresses of the replicas of each group
+	addresses map[string][]string
+}
+
+// Load reads a shard map from a JSON file mapping every group ID to the client addresses of its replicas,
+// e.g. {"a": ["host1:8080", "host2:8080"], "b": ["host3:8080"]}. Replicas and clients must use the same map.
+func Load(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var addresses map[string][]string
+	if err := json.Unmarshal(data, &addresses); err != nil {
+		return nil, err
+	}
+
+	m := &Map{addresses: addresses}
+	var errs []error
+	for group, addrs := range addresses {
+		if group == "" {
+			errs = append(errs, errors.New("missing group ID"))
+		}
+		if len(addrs) == 0 {
+			errs = append(errs, fmt.Errorf("group %s has no addresses", group))
+		}
+		for _, addr := range addrs {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				errs = append(errs, fmt.Errorf("group %s: %v", group, err))
+			}
+		}
+		m.groups = append(m.groups, group)
+	}
+	if len(m.groups) == 0 {
+		errs = append(errs, errors.New("no groups are listed"))
+	}
+	sort.Strings(m.groups)
+	return m, errors.Join(errs...)
+}
+
+// Groups returns the IDs of every group in order
+func (m *Map) Groups() []string {
+	return m.groups
+}
+
+// Addresses returns the client addresses of the replicas of a group, reporting whether the group exists
+func (m *Map) Addresses(group string) ([]string, bool) {
+	addrs, ok := m.addresses[group]
+	return addrs, ok
+}
+
+// Owner returns the group that allocated an auction ID, which hosts the auction unless it was moved
+func (m *Map) Owner(auctionId int64) string {
+	if auctionId <= 0 {
+		return ""
+	}
+	return m.groups[(auctionId-1)%int64(len(m.groups))]
+}
+
+// NextAuctionId returns the first auction ID after the given one that the group allocates
+func (m *Map) NextAuctionId(group string, after int64) int64 {
+	index := int64(sort.SearchStrings(m.groups, group))
+	count := int64(len(m.groups))
+
+	// The group allocates index+1, index+1+count, index+1+2*count and so on
+	id := index + 1
+	if after >= id {
+		id += ((after-id)/count + 1) * count
+	}
+	return id
+}
+
+// Dial connects to the first of the addresses that accepts connections. Only the primary of a group serves
+// clients, so when it fails the connection moves on to whichever replica is elected next.
+func Dial(addrs []string, options ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if len(addrs) == 1 {
+		return grpc.Dial(addrs[0], options...)
+	}
+
+	servers := manual.NewBuilderWithScheme("auction")
+	var state resolver.State
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	servers.InitialState(state)
+	return grpc.Dial(servers.Scheme()+":///replicas", append(options, grpc.WithResolvers(servers))...)
+}
@@ -0,0 +1,179 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Files are the paths of a certificate, its key and the CA certificate used to verify peers.
+// Any of them may be empty if not needed.
+type Files struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+// RegisterFlags adds flags for the certificate paths to the command line
+func RegisterFlags() *Files {
+	files := &Files{}
+	flag.StringVar(&files.Cert, "tls-cert", "", "PEM certificate to present, enables TLS")
+	flag.StringVar(&files.Key, "tls-key", "", "PEM private key of the certificate")
+	flag.StringVar(&files.CA, "tls-ca", "", "PEM CA certificate used to verify peers")
+	return files
+}
+
+// Reloader provides TLS configurations whose certificates are reloaded from disk
+// when the files change, so certificates can be rotated without restarting
+type Reloader struct {
+	files Files
+
+	// Called when changed files can't be loaded, in which case the previous certificates are kept
+	OnReloadError func(error)
+
+	mut      sync.Mutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes [3]time.Time
+}
+
+// NewReloader loads the certificates, failing if any of the given files are invalid
+func NewReloader(files Files) (*Reloader, error) {
+	if (files.Cert == "") != (files.Key == "") {
+		return nil, errors.New("a TLS certificate and key must be given together")
+	}
+
+	r := &Reloader{files: files}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the files again if any of them have been modified. Must be called with mut held.
+func (r *Reloader) reload() error {
+	var modTimes [3]time.Time
+	for i, path := range []string{r.files.Cert, r.files.Key, r.files.CA} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if modTimes == r.modTimes && (r.cert != nil || r.pool != nil) {
+		return nil
+	}
+
+	var cert *tls.Certificate
+	if r.files.Cert != "" {
+		loaded, err := tls.LoadX509KeyPair(r.files.Cert, r.files.Key)
+		if err != nil {
+			return err
+		}
+		cert = &loaded
+	}
+
+	var pool *x509.CertPool
+	if r.files.CA != "" {
+		data, err := os.ReadFile(r.files.CA)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no certificates found", r.files.CA)
+		}
+	}
+
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+	return nil
+}
+
+// current returns the latest certificate and CA pool, keeping the previous ones if reloading fails
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if err := r.reload(); err != nil && r.OnReloadError != nil {
+		r.OnReloadError(err)
+	}
+	return r.cert, r.pool
+}
+
+// ServerConfig is used by servers that present a certificate but don't verify clients
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+}
+
+// MutualServerConfig is used by servers that require clients to present a certificate signed by the CA
+func (r *Reloader) MutualServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, errors.New("no TLS certificate configured")
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig is used by clients verifying the server against the CA, presenting
+// their own certificate if one is configured
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+		// The server is verified in VerifyConnection instead, against the latest CA
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			_, pool := r.current()
+			return verifyServer(state, pool, serverName)
+		},
+	}
+}
+
+// verifyServer performs the verification crypto/tls does by default, with the given CA pool
+func verifyServer(state tls.ConnectionState, pool *x509.CertPool, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         pool,
+		Intermediates: intermediates,
+	})
+	return err
+}
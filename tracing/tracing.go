@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Directory holding trace files unless another file is given
+const defaultDirectory = "traces"
+
+// Options configure where a process exports its spans
+type Options struct {
+	Exporter string
+	Endpoint string
+	File     string
+}
+
+// RegisterFlags adds flags for the tracing options to the command line
+func RegisterFlags() *Options {
+	options := &Options{}
+	flag.StringVar(&options.Exporter, "trace-exporter", "none", "where to export spans: none, otlp or file")
+	flag.StringVar(&options.Endpoint, "trace-endpoint", "localhost:4317", "address of the OTLP collector when exporting with otlp")
+	flag.StringVar(&options.File, "trace-file", "", "file to write spans to when exporting with file (default traces/<process>-<id>.json)")
+	return options
+}
+
+// Setup installs the global tracer provider and W3C trace context propagation for the process.
+// The returned function flushes any buffered spans and must be called before exiting.
+func Setup(options Options, process string, id string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch options.Exporter {
+	case "none":
+		// Trace context is still propagated so spans of other processes are connected
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpoint(options.Endpoint), otlptracegrpc.WithInsecure())
+	case "file":
+		exporter, err = fileExporter(options.File, process, id)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", options.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "auction-"+process),
+			attribute.String("service.instance.id", id),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// fileExporter writes spans as JSON to a file
+func fileExporter(path string, process string, id string) (sdktrace.SpanExporter, error) {
+	if path == "" {
+		path = filepath.Join(defaultDirectory, process+"-"+id+".json")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return stdouttrace.New(stdouttrace.WithWriter(file))
+}